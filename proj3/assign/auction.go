@@ -0,0 +1,141 @@
+package assign
+
+import "math"
+
+// auctionRounds is the number of times epsilon is divided by auctionScale before the last round
+const auctionRounds = 8
+
+// auctionScale is the factor epsilon is divided by after each round
+const auctionScale = 5
+
+// slotHeap orders the slots of a column by price, so that bidders always take the cheapest copy of the column
+type slotHeap struct {
+	slots  []int
+	prices []float64
+}
+
+// fix moves the cheapest slot back to the top after its price was raised
+func (h *slotHeap) fix() {
+	for i := 0; ; {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h.slots) && h.prices[h.slots[child]] < h.prices[h.slots[smallest]] {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		h.slots[i], h.slots[smallest] = h.slots[smallest], h.slots[i]
+		i = smallest
+	}
+}
+
+// second returns the price of the second cheapest slot of the column, which is infinite if it has one slot
+func (h *slotHeap) second() float64 {
+	price := math.Inf(1)
+	for _, child := range []int{1, 2} {
+		if child < len(h.slots) {
+			price = math.Min(price, h.prices[h.slots[child]])
+		}
+	}
+	return price
+}
+
+// Auction solves the assignment of rows to columns approximately with the auction algorithm, where each column
+// can take up to capacity rows. cost computes the cost of assigning a row to a column on demand, so that no cost
+// matrix is held in memory. There must be no more rows than columns times capacity. Epsilon starts at a fraction
+// of the cost range and is scaled down over auctionRounds rounds, leaving the total cost within rows times the
+// final epsilon of the optimum. Returns the column assigned to each row
+// reference: Bertsekas, "Auction algorithms for network flow problems: a tutorial introduction", 1992
+func Auction(rows int, columns int, capacity int, cost func(row int, column int) float64) []int {
+	result := make([]int, rows)
+	if rows == 0 {
+		return result
+	}
+	// pads the problem to a square one with rows which cost nothing in any column, as the forward auction
+	// only converges to the optimum once every slot is taken
+	bidders := columns * capacity
+	bidCost := func(i int, j int) float64 {
+		if i >= rows {
+			return 0
+		}
+		return cost(i, j)
+	}
+
+	// the cost range sets the first epsilon
+	low, high := math.Inf(1), math.Inf(-1)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			c := cost(i, j)
+			low, high = math.Min(low, c), math.Max(high, c)
+		}
+	}
+	epsilon := (high - low) / auctionScale
+	if epsilon <= 0 {
+		epsilon = 1
+	}
+
+	// every column has capacity slots, whose prices are kept between the rounds
+	prices := make([]float64, columns*capacity)
+	heaps := make([]slotHeap, columns)
+	for j := range heaps {
+		heaps[j] = slotHeap{slots: make([]int, capacity), prices: prices}
+		for k := range heaps[j].slots {
+			heaps[j].slots[k] = j*capacity + k
+		}
+	}
+	owners := make([]int, len(prices))
+	assigned := make([]int, bidders)
+
+	for round := 0; round <= auctionRounds; round++ {
+		for s := range owners {
+			owners[s] = -1
+		}
+		queue := make([]int, bidders)
+		for i := range queue {
+			queue[i] = i
+		}
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+
+			// finds the best and second best values of the cheapest slots, the second best
+			// possibly being the next slot of the best column
+			best, bestValue, secondValue := -1, math.Inf(-1), math.Inf(-1)
+			for j := range heaps {
+				c := bidCost(i, j)
+				value := -c - prices[heaps[j].slots[0]]
+				if value > bestValue {
+					if best >= 0 {
+						secondValue = bestValue
+					}
+					best, bestValue = j, value
+					secondValue = math.Max(secondValue, -c-heaps[j].second())
+				} else if value > secondValue {
+					secondValue = value
+				}
+			}
+
+			// raises the price of the slot by the margin over the second best plus epsilon
+			slot := heaps[best].slots[0]
+			increment := epsilon
+			if !math.IsInf(secondValue, -1) {
+				increment += bestValue - secondValue
+			}
+			prices[slot] += increment
+			heaps[best].fix()
+			if owner := owners[slot]; owner >= 0 {
+				queue = append(queue, owner)
+			}
+			owners[slot] = i
+			assigned[i] = slot
+		}
+		epsilon /= auctionScale
+	}
+
+	for i := range result {
+		result[i] = assigned[i] / capacity
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package assign
+
+import "math"
+
+// Hungarian solves the rectangular assignment problem with the Hungarian algorithm.
+// cost[i][j] is the cost of assigning row i to column j, and there must be at least as many columns as rows.
+// Returns the column assigned to each row, such that the total cost is minimal and no column is used twice.
+// reference: https://cp-algorithms.com/graph/hungarian-algorithm.html
+func Hungarian(cost [][]float64) []int {
+	n := len(cost)
+	if n == 0 {
+		return []int{}
+	}
+	m := len(cost[0])
+
+	// potentials and matching use 1-based indices, with 0 as a virtual column
+	u := make([]float64, n+1)
+	v := make([]float64, m+1)
+	match := make([]int, m+1)
+	way := make([]int, m+1)
+	minv := make([]float64, m+1)
+	used := make([]bool, m+1)
+
+	for i := 1; i <= n; i++ {
+		match[0] = i
+		j0 := 0
+		for j := 0; j <= m; j++ {
+			minv[j] = math.Inf(1)
+			used[j] = false
+		}
+		for match[j0] != 0 {
+			used[j0] = true
+			i0 := match[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		// flips the augmenting path
+		for j0 != 0 {
+			j1 := way[j0]
+			match[j0] = match[j1]
+			j0 = j1
+		}
+	}
+
+	result := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if match[j] != 0 {
+			result[match[j]-1] = j - 1
+		}
+	}
+	return result
+}
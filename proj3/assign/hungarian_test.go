@@ -0,0 +1,128 @@
+package assign
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// bruteForce finds the minimal total cost of assigning every row to a distinct column by trying every assignment
+func bruteForce(cost [][]float64) float64 {
+	used := make([]bool, len(cost[0]))
+	var search func(row int) float64
+	search = func(row int) float64 {
+		if row == len(cost) {
+			return 0
+		}
+		best := math.Inf(1)
+		for j := range used {
+			if !used[j] {
+				used[j] = true
+				best = math.Min(best, cost[row][j]+search(row+1))
+				used[j] = false
+			}
+		}
+		return best
+	}
+	return search(0)
+}
+
+// randomCost makes a rows x columns cost matrix with costs in [0, 1)
+func randomCost(rng *rand.Rand, rows int, columns int) [][]float64 {
+	cost := make([][]float64, rows)
+	for i := range cost {
+		cost[i] = make([]float64, columns)
+		for j := range cost[i] {
+			cost[i][j] = rng.Float64()
+		}
+	}
+	return cost
+}
+
+// checkAssignment checks that no column takes more than capacity rows and returns the total cost
+func checkAssignment(t *testing.T, cost [][]float64, columns []int, capacity int) float64 {
+	t.Helper()
+	if len(columns) != len(cost) {
+		t.Fatalf("%d columns returned for %d rows", len(columns), len(cost))
+	}
+	counts := map[int]int{}
+	var total float64
+	for i, j := range columns {
+		counts[j]++
+		if j < 0 || j >= len(cost[i]) || counts[j] > capacity {
+			t.Fatalf("column %d is out of range or used more than %d times", j, capacity)
+		}
+		total += cost[i][j]
+	}
+	return total
+}
+
+func TestHungarian(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    int
+		columns int
+	}{
+		{"single", 1, 1},
+		{"square", 6, 6},
+		{"more columns", 4, 8},
+		{"one row", 1, 7},
+		{"square larger", 8, 8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			for trial := 0; trial < 20; trial++ {
+				cost := randomCost(rng, test.rows, test.columns)
+				total := checkAssignment(t, cost, Hungarian(cost), 1)
+				if want := bruteForce(cost); math.Abs(total-want) > 1e-9 {
+					t.Fatalf("total cost %v, want %v", total, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHungarianEmpty(t *testing.T) {
+	if columns := Hungarian(nil); len(columns) != 0 {
+		t.Fatalf("Hungarian(nil) = %v", columns)
+	}
+}
+
+func TestAuction(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     int
+		columns  int
+		capacity int
+	}{
+		{"square", 7, 7, 1},
+		{"more columns", 4, 8, 1},
+		{"capacity", 8, 4, 2},
+		{"capacity with spare slots", 7, 3, 3},
+		{"one column", 3, 1, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			for trial := 0; trial < 20; trial++ {
+				cost := randomCost(rng, test.rows, test.columns)
+				columns := Auction(test.rows, test.columns, test.capacity, func(row int, column int) float64 {
+					return cost[row][column]
+				})
+				total := checkAssignment(t, cost, columns, test.capacity)
+
+				// the optimum repeats each column capacity times for the brute force
+				expanded := make([][]float64, test.rows)
+				for i := range expanded {
+					for c := 0; c < test.capacity; c++ {
+						expanded[i] = append(expanded[i], cost[i]...)
+					}
+				}
+				if want := bruteForce(expanded); total > want+float64(test.rows)*1e-6 {
+					t.Fatalf("total cost %v, want %v", total, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package knn
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Neighbor represents a point found by a nearest neighbour query
+type Neighbor struct {
+	Index int
+	Dist  float64
+}
+
+// node represents a k-d tree node holding one point and the live point count of its subtree
+type node struct {
+	point  int
+	axis   int
+	alive  int32
+	parent *node
+	left   *node
+	right  *node
+}
+
+// Tree represents a k-d tree over feature vectors using squared euclidean distance.
+// Queries are safe for concurrent use, and points can be removed while queries are running.
+type Tree struct {
+	root    *node
+	points  [][]float64
+	nodes   []*node
+	removed []int32
+	size    int32
+}
+
+// NewTree builds a balanced k-d tree from the points. The points must all have the same length
+func NewTree(points [][]float64) *Tree {
+	tree := &Tree{
+		points:  points,
+		nodes:   make([]*node, len(points)),
+		removed: make([]int32, len(points)),
+		size:    int32(len(points)),
+	}
+	indices := make([]int, len(points))
+	for i := range indices {
+		indices[i] = i
+	}
+	tree.root = tree.build(indices, 0, nil)
+	return tree
+}
+
+// build recursively splits the indices at the median of the axis
+func (tree *Tree) build(indices []int, depth int, parent *node) *node {
+	if len(indices) == 0 {
+		return nil
+	}
+	axis := 0
+	if len(tree.points[indices[0]]) > 0 {
+		axis = depth % len(tree.points[indices[0]])
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return tree.points[indices[i]][axis] < tree.points[indices[j]][axis]
+	})
+	mid := len(indices) / 2
+	n := &node{point: indices[mid], axis: axis, alive: int32(len(indices)), parent: parent}
+	tree.nodes[n.point] = n
+	n.left = tree.build(indices[:mid], depth+1, n)
+	n.right = tree.build(indices[mid+1:], depth+1, n)
+	return n
+}
+
+// Len returns the number of points which are not removed
+func (tree *Tree) Len() int {
+	return int(atomic.LoadInt32(&tree.size))
+}
+
+// Removed checks if a point is removed
+func (tree *Tree) Removed(index int) bool {
+	return atomic.LoadInt32(&tree.removed[index]) == 1
+}
+
+// Remove removes a point from the tree. Returns false if it was already removed
+func (tree *Tree) Remove(index int) bool {
+	if !atomic.CompareAndSwapInt32(&tree.removed[index], 0, 1) {
+		return false
+	}
+	atomic.AddInt32(&tree.size, -1)
+	for n := tree.nodes[index]; n != nil; n = n.parent {
+		atomic.AddInt32(&n.alive, -1)
+	}
+	return true
+}
+
+// Nearest returns up to k points which are closest to the query, sorted by distance
+func (tree *Tree) Nearest(query []float64, k int) []Neighbor {
+	if k < 1 {
+		return nil
+	}
+	result := make([]Neighbor, 0, k)
+	tree.search(tree.root, query, k, &result)
+	return result
+}
+
+// search visits the subtree, keeping the k closest live points in result
+func (tree *Tree) search(n *node, query []float64, k int, result *[]Neighbor) {
+	if n == nil || atomic.LoadInt32(&n.alive) == 0 {
+		return
+	}
+	if !tree.Removed(n.point) {
+		insert(result, Neighbor{n.point, Distance(query, tree.points[n.point])}, k)
+	}
+	diff := query[n.axis] - tree.points[n.point][n.axis]
+	near, far := n.left, n.right
+	if diff > 0 {
+		near, far = n.right, n.left
+	}
+	tree.search(near, query, k, result)
+	if len(*result) < k || diff*diff < (*result)[len(*result)-1].Dist {
+		tree.search(far, query, k, result)
+	}
+}
+
+// insert adds a neighbor into the sorted result, keeping at most k entries
+func insert(result *[]Neighbor, neighbor Neighbor, k int) {
+	neighbors := *result
+	if len(neighbors) == k && neighbor.Dist >= neighbors[k-1].Dist {
+		return
+	}
+	i := sort.Search(len(neighbors), func(i int) bool {
+		return neighbors[i].Dist > neighbor.Dist
+	})
+	if len(neighbors) < k {
+		neighbors = append(neighbors, Neighbor{})
+	}
+	copy(neighbors[i+1:], neighbors[i:])
+	neighbors[i] = neighbor
+	*result = neighbors
+}
+
+// Distance computes the squared euclidean distance between two vectors
+func Distance(a []float64, b []float64) float64 {
+	var dist float64
+	for i := range a {
+		d := a[i] - b[i]
+		dist += d * d
+	}
+	return dist
+}
@@ -0,0 +1,124 @@
+package knn
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// bruteNearest returns the k closest live points by scanning all of them
+func bruteNearest(points [][]float64, removed map[int]bool, query []float64, k int) []Neighbor {
+	neighbors := []Neighbor{}
+	for i, point := range points {
+		if !removed[i] {
+			neighbors = append(neighbors, Neighbor{i, Distance(query, point)})
+		}
+	}
+	sort.SliceStable(neighbors, func(i, j int) bool {
+		return neighbors[i].Dist < neighbors[j].Dist
+	})
+	return neighbors[:min(k, len(neighbors))]
+}
+
+// randomPoints makes count points of the dimension with coordinates in [0, 1)
+func randomPoints(rng *rand.Rand, count int, dim int) [][]float64 {
+	points := make([][]float64, count)
+	for i := range points {
+		points[i] = make([]float64, dim)
+		for j := range points[i] {
+			points[i][j] = rng.Float64()
+		}
+	}
+	return points
+}
+
+func TestNearest(t *testing.T) {
+	tests := []struct {
+		name   string
+		count  int
+		dim    int
+		k      int
+		remove int
+	}{
+		{"empty", 0, 3, 1, 0},
+		{"single", 1, 3, 1, 0},
+		{"k above count", 5, 3, 8, 0},
+		{"color", 200, 3, 1, 0},
+		{"color k", 200, 3, 16, 0},
+		{"grid", 300, 27, 8, 0},
+		{"removed", 200, 3, 8, 150},
+		{"all removed", 50, 3, 4, 50},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			points := randomPoints(rng, test.count, test.dim)
+			tree := NewTree(points)
+			removed := map[int]bool{}
+			for _, i := range rng.Perm(test.count)[:test.remove] {
+				if !tree.Remove(i) {
+					t.Fatalf("Remove(%d) = false on a live point", i)
+				}
+				removed[i] = true
+			}
+			if tree.Len() != test.count-test.remove {
+				t.Fatalf("Len() = %d, want %d", tree.Len(), test.count-test.remove)
+			}
+			for q := 0; q < 50; q++ {
+				query := randomPoints(rng, 1, test.dim)[0]
+				got := tree.Nearest(query, test.k)
+				want := bruteNearest(points, removed, query, test.k)
+				if len(got) != len(want) {
+					t.Fatalf("Nearest returned %d points, want %d", len(got), len(want))
+				}
+				for i := range got {
+					if got[i].Dist != want[i].Dist {
+						t.Fatalf("neighbour %d at distance %v, want %v", i, got[i].Dist, want[i].Dist)
+					}
+					if removed[got[i].Index] {
+						t.Fatalf("removed point %d returned", got[i].Index)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveTwice(t *testing.T) {
+	tree := NewTree(randomPoints(rand.New(rand.NewSource(1)), 10, 3))
+	if !tree.Remove(4) || tree.Remove(4) {
+		t.Fatal("a point must be removed exactly once")
+	}
+	if !tree.Removed(4) || tree.Removed(5) || tree.Len() != 9 {
+		t.Fatal("removal state is wrong")
+	}
+}
+
+// TestConcurrentRemove takes the nearest point from several goroutines until the tree is empty, and
+// checks that each point is taken exactly once
+func TestConcurrentRemove(t *testing.T) {
+	points := randomPoints(rand.New(rand.NewSource(1)), 500, 3)
+	tree := NewTree(points)
+	taken := make([]int32, len(points))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for tree.Len() > 0 {
+				result := tree.Nearest(randomPoints(rng, 1, 3)[0], 1)
+				if len(result) > 0 && tree.Remove(result[0].Index) {
+					taken[result[0].Index]++
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	for i, count := range taken {
+		if count != 1 {
+			t.Fatalf("point %d removed %d times", i, count)
+		}
+	}
+}
@@ -3,8 +3,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image/color"
+	"math"
 	"os"
+	"proj3/png"
 	"proj3/scheduler"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ErrorExit prints error and usage then exit the application
@@ -15,28 +21,209 @@ func ErrorExit(errMessage string) {
 	os.Exit(1)
 }
 
+// parseSize parses a size given as one number, or as width x height such as 60x40
+func parseSize(size string) (int, int, bool) {
+	widthText, heightText, found := strings.Cut(strings.ToLower(size), "x")
+	if !found {
+		heightText = widthText
+	}
+	width, err := strconv.Atoi(widthText)
+	if err != nil {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(heightText)
+	if err != nil {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// parseColor parses a color given as #rrggbb
+func parseColor(text string) (color.RGBA64, bool) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(text, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(text, "#")) != 6 {
+		return color.RGBA64{}, false
+	}
+	r, g, b := uint16(value>>16&0xff), uint16(value>>8&0xff), uint16(value&0xff)
+	return color.RGBA64{r * 0x101, g * 0x101, b * 0x101, 0xffff}, true
+}
+
+// parsePrintSize parses a print size given as width x height such as 10x8 or 8.5x11. A size of 0 follows the other one
+func parsePrintSize(size string) (float64, float64, bool) {
+	widthText, heightText, found := strings.Cut(strings.ToLower(size), "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, err := strconv.ParseFloat(widthText, 64)
+	if err != nil || width < 0 {
+		return 0, 0, false
+	}
+	height, err := strconv.ParseFloat(heightText, 64)
+	if err != nil || height < 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// validFilter checks if the resampling filter exists
+func validFilter(filter string) bool {
+	return filter == "nearest" || filter == "bilinear" || filter == "bicubic" || filter == "lanczos"
+}
+
 func main() {
 	inImg := flag.String("i", "", "Path to the input image")
-	outImg := flag.String("o", "", "Path to the output image")
+	outImg := flag.String("o", "", "Path to the output image, or to a .dzi file to save a Deep Zoom tile pyramid with a viewer page")
+	quality := flag.Int("quality", 90, "Quality of a JPEG output image (1 - 100)")
+	pngCompression := flag.String("png-compression", "default", "compression of a PNG output image: default, none, speed, best")
+	tiffCompression := flag.String("tiff-compression", "lzw", "compression of a TIFF output image: none, packbits, lzw")
+	pyramidTile := flag.Int("pyramid-tile", 256, "Size of the tiles of a Deep Zoom output in pixels. Must be positive")
+	pyramidOverlap := flag.Int("pyramid-overlap", 1, "Overlap of neighbouring tiles of a Deep Zoom output in pixels. Must be smaller than 'pyramid-tile'")
+	pyramidFormat := flag.String("pyramid-format", "jpg", "image format of the tiles of a Deep Zoom output: jpg(default), png")
+	maxMem := flag.Int("max-mem", 0, "Memory limit of the upscaled image in megabytes. The output is rendered in bands of rows and streamed to a PNG output. 0 for no limit")
 	tilesDir := flag.String("d", "", "Path to the mosaic tiles directory")
-	tileSize := flag.Int("s", 0, "Size of mosaic tiles in pixels. Must be positive")
+	tileSize := flag.String("s", "", "Size of mosaic tiles in pixels, either one size for square tiles or width x height such as 60x40. Must be positive")
 	upscale := flag.Int("U", 1, "Input image upscaling in integer. Must be positive")
+	fit := flag.String("fit", "stretch", "how tile images are fitted to the tile size: stretch(default), crop=center crop, letterbox=whole image on the fill color, smart=crop with the most detail")
+	fitFill := flag.String("fit-fill", "#000000", "Fill color of the letterbox fit as #rrggbb")
+	smartMeasure := flag.String("smart-measure", "entropy", "detail measure of the smart fit: entropy=luminance entropy(default), edge=edge density")
+	tileFilter := flag.String("tile-filter", "nearest", "resampling filter of tile images: nearest=nearest neighbour(default), bilinear, bicubic=Catmull-Rom, lanczos=Lanczos-3")
+	upscaleFilter := flag.String("upscale-filter", "nearest", "resampling filter of input upscaling: nearest=nearest neighbour(default), bilinear, bicubic=Catmull-Rom, lanczos=Lanczos-3")
+	outWidth := flag.Int("width", 0, "Width of the output image in pixels instead of upscaling. 0 to follow the height")
+	outHeight := flag.Int("height", 0, "Height of the output image in pixels instead of upscaling. 0 to follow the width")
+	megapixels := flag.Float64("megapixels", 0, "Size of the output image in megapixels instead of upscaling. 0 for no size")
+	printSize := flag.String("print", "", "Print size of the output image as width x height such as 10x8 instead of upscaling, in 'print-unit' at 'dpi'")
+	printUnit := flag.String("print-unit", "in", "unit of the print size: in=inches(default), cm=centimeters")
+	dpi := flag.Float64("dpi", 300, "Resolution of the print size in dots per inch. Must be positive")
+	keepAspect := flag.Bool("keep-aspect", true, "Keep the aspect ratio of the input if both width and height are given, fitting the output inside them")
+	edge := flag.String("edge", "crop", "policy for an output which is not a multiple of the tile size: crop=clipped last tiles(default), extend=output grown to whole tiles, shrink=output cut to whole tiles, distribute=tiles resized to fit evenly. Explicit output sizes are always distributed")
+	gap := flag.Int("gap", 0, "Width of the grout between tiles in pixels. 0 for no grout")
+	groutColor := flag.String("grout", "#808080", "Grout color as #rrggbb")
+	groutTexture := flag.String("grout-texture", "", "Path to an image repeated as the grout instead of the grout color")
+	corner := flag.Float64("corner", 0, "Corner radius of rectangular tiles in pixels. 0 for square corners")
 	intensity := flag.Float64("I", 0.8, "intensity of mosaic images in float (0.0 - 1.0). 1.0 for full mosaic images, 0.0 for input image only")
 	blendin := flag.Float64("B", 0.8, "intensity of tile images color blend-in in float (0.0 - 1.0). 1.0 for full blend in, 0.0 for tiles image only")
 	runMode := flag.String("M", "s", "running mode: s=sequential(default), p=parallel, w=parallel with work steal")
 	threads := flag.Int("T", 1, "Number of goroutines. ignored if sequential. Must be positive")
+	layout := flag.String("layout", "grid", "cell layout: grid=rectangular tiles(default), quadtree=tiles split into quarters where the input has detail, hex=hexagonal tiles, voronoi=irregular tiles around scattered seeds, brick=rows shifted by 'row-offset', herringbone, basket=basket weave. Herringbone and basket weave take tiles whose long side is a multiple of the short side")
+	hexOrient := flag.String("hex-orient", "pointy", "orientation of the hex layout: pointy=pointy top(default), flat=flat top")
+	rowOffset := flag.Float64("row-offset", 0.5, "Shift of each row of the brick layout from the previous row, as a fraction of the tile width (0.0 - 1.0)")
+	voronoiSeeds := flag.String("voronoi-seeds", "poisson", "seed scattering of the voronoi layout: poisson=Poisson-disk sampling(default), uniform, detail=denser where the input has detail by 'split-measure'")
+	minTile := flag.Int("min-tile", 0, "Minimum size of the shorter side of quadtree tiles in pixels. 0 for a quarter of the shorter tile side")
+	splitThreshold := flag.Float64("split", 0.1, "Detail above which a quadtree tile is split")
+	splitMeasure := flag.String("split-measure", "variance", "detail measure of the quadtree: variance=color standard deviation(default), edge=edge energy")
+	match := flag.String("match", "random", "tile selection mode: random(default), best=closest feature to the tile position, softmax=sampled from the closest tiles, assign=optimal one-to-one assignment of tiles to positions, approximated by an auction on large grids")
+	topK := flag.Int("top-k", 16, "Number of closest tiles sampled by the softmax mode. 0 for all tiles")
+	temperature := flag.Float64("temperature", 1.0, "Temperature of the softmax mode, relative to the spread of tile distances. 0 for best match, inf for random")
+	feature := flag.String("feature", "color", "matching feature: color=mean color(default), hist=color histogram, grid=grid of mean Lab colors")
+	maxUses := flag.Int("max-uses", 0, "Maximum number of times a tile can be used. 0 for unlimited")
+	minDist := flag.Float64("min-dist", 0, "Minimum distance in cells between two placements of the same tile. 0 for no limit")
+	distNorm := flag.String("dist-norm", "manhattan", "cell distance used by 'min-dist': manhattan(default), euclidean")
+	refine := flag.Int("refine", 0, "Number of local search iterations refining the tile placement. 0 for no iteration limit, which leaves the refinement off unless 'refine-time' is set")
+	refineTime := flag.Duration("refine-time", 0, "Time budget of the refinement, such as 5s. 0 for no time limit. The refinement runs if either budget is set. A time budget makes the output depend on timing")
+	refinePenalty := flag.Float64("refine-penalty", 1.0, "Penalty of neighbouring duplicate tiles during refinement, relative to the mean match error")
+	seed := flag.Int64("seed", 0, "Seed of the random tile selection. The same seed gives the same output in every running mode. 0 for a random seed")
+	colorDist := flag.String("color-dist", "rgb", "color distance of the color and grid features: rgb=euclidean distance of raw values(default), cie76, cie94, ciede2000")
+	gridSize := flag.Int("grid", 3, "Number of rows and columns of the grid feature. Must be positive")
+	metric := flag.String("metric", "chi", "histogram distance metric: chi=chi-square(default), bhatt=Bhattacharyya, inter=intersection, emd=earth mover's distance")
 
 	flag.Parse()
 
 	if *inImg == "" || *outImg == "" || *tilesDir == "" {
 		ErrorExit("'i','o','d' flag is required")
 	}
-	if *tileSize < 1 {
+	if _, err := png.CodecForPath(*outImg); err != nil && !png.IsPyramidPath(*outImg) {
+		ErrorExit("'o' must have the extension of a supported image format or .dzi")
+	}
+	if *pyramidTile <= 0 {
+		ErrorExit("'pyramid-tile' must be positive")
+	}
+	if *pyramidOverlap < 0 || *pyramidOverlap >= *pyramidTile {
+		ErrorExit("'pyramid-overlap' must not be negative and must be smaller than 'pyramid-tile'")
+	}
+	if *pyramidFormat != "jpg" && *pyramidFormat != "png" {
+		ErrorExit("'pyramid-format' must be: jpg, png")
+	}
+	if *maxMem < 0 {
+		ErrorExit("'max-mem' must not be negative")
+	}
+	if codec, err := png.CodecForPath(*outImg); *maxMem > 0 && (err != nil || codec.Name != "png") {
+		ErrorExit("'max-mem' needs a .png output")
+	}
+	if *quality < 1 || *quality > 100 {
+		ErrorExit("'quality' must be from 1 to 100")
+	}
+	if _, found := png.PNGCompressions[*pngCompression]; !found {
+		ErrorExit("'png-compression' must be: default, none, speed, best")
+	}
+	if _, found := png.TIFFCompressions[*tiffCompression]; !found {
+		ErrorExit("'tiff-compression' must be: none, packbits, lzw")
+	}
+	tileWidth, tileHeight, ok := parseSize(*tileSize)
+	if !ok || tileWidth < 1 || tileHeight < 1 {
 		ErrorExit("'s' flag is required and must be positive")
 	}
+	if *fit != "stretch" && *fit != "crop" && *fit != "letterbox" && *fit != "smart" {
+		ErrorExit("'fit' must be: stretch, crop, letterbox, smart")
+	}
+	fillColor, ok := parseColor(*fitFill)
+	if !ok {
+		ErrorExit("'fit-fill' must be a color as #rrggbb")
+	}
+	if *smartMeasure != "entropy" && *smartMeasure != "edge" {
+		ErrorExit("'smart-measure' must be: entropy, edge")
+	}
+	if !validFilter(*tileFilter) || !validFilter(*upscaleFilter) {
+		ErrorExit("'tile-filter' and 'upscale-filter' must be: nearest, bilinear, bicubic, lanczos")
+	}
 	if *upscale < 1 {
 		ErrorExit("'U' must be positive")
 	}
+	if *outWidth < 0 || *outHeight < 0 || *megapixels < 0 {
+		ErrorExit("'width', 'height' and 'megapixels' must not be negative")
+	}
+	if *gap < 0 {
+		ErrorExit("'gap' must not be negative")
+	}
+	groutFill, ok := parseColor(*groutColor)
+	if !ok {
+		ErrorExit("'grout' must be a color as #rrggbb")
+	}
+	if *corner < 0 {
+		ErrorExit("'corner' must not be negative")
+	}
+	if *edge != "crop" && *edge != "extend" && *edge != "shrink" && *edge != "distribute" {
+		ErrorExit("'edge' must be: crop, extend, shrink, distribute")
+	}
+	if *printUnit != "in" && *printUnit != "cm" {
+		ErrorExit("'print-unit' must be: in, cm")
+	}
+	if *dpi <= 0 {
+		ErrorExit("'dpi' must be positive")
+	}
+	sizes := 0
+	for _, given := range []bool{*outWidth > 0 || *outHeight > 0, *megapixels > 0, *printSize != "", *upscale != 1} {
+		if given {
+			sizes++
+		}
+	}
+	if sizes > 1 {
+		ErrorExit("only one of 'U', 'width' and 'height', 'megapixels', 'print' can be given")
+	}
+	if *printSize != "" {
+		printWidth, printHeight, ok := parsePrintSize(*printSize)
+		if !ok {
+			ErrorExit("'print' must be width x height such as 10x8")
+		}
+		if *printUnit == "cm" {
+			printWidth /= 2.54
+			printHeight /= 2.54
+		}
+		*outWidth = int(math.Round(printWidth * *dpi))
+		*outHeight = int(math.Round(printHeight * *dpi))
+		if *outWidth < 1 && *outHeight < 1 {
+			ErrorExit("'print' must be at least one dot")
+		}
+	}
 	if *intensity < 0.0 || *intensity > 1.0 {
 		ErrorExit("'I' must be from 0.0 to 1.0")
 	}
@@ -49,16 +236,123 @@ func main() {
 	if *threads < 1 {
 		ErrorExit("'T' must be positive")
 	}
+	if *layout != "grid" && *layout != "quadtree" && *layout != "hex" && *layout != "voronoi" &&
+		*layout != "brick" && *layout != "herringbone" && *layout != "basket" {
+		ErrorExit("'layout' must be: grid, quadtree, hex, voronoi, brick, herringbone, basket")
+	}
+	if *rowOffset < 0.0 || *rowOffset > 1.0 {
+		ErrorExit("'row-offset' must be from 0.0 to 1.0")
+	}
+	if *voronoiSeeds != "poisson" && *voronoiSeeds != "uniform" && *voronoiSeeds != "detail" {
+		ErrorExit("'voronoi-seeds' must be: poisson, uniform, detail")
+	}
+	if *hexOrient != "pointy" && *hexOrient != "flat" {
+		ErrorExit("'hex-orient' must be: pointy, flat")
+	}
+	if *minTile < 0 {
+		ErrorExit("'min-tile' must not be negative")
+	}
+	if *splitMeasure != "variance" && *splitMeasure != "edge" {
+		ErrorExit("'split-measure' must be: variance, edge")
+	}
+	if *match != "random" && *match != "best" && *match != "softmax" && *match != "assign" {
+		ErrorExit("'match' must be: random, best, softmax, assign")
+	}
+	if *topK < 0 {
+		ErrorExit("'top-k' must not be negative")
+	}
+	if *temperature < 0 || math.IsNaN(*temperature) {
+		ErrorExit("'temperature' must not be negative")
+	}
+	if *feature != "color" && *feature != "hist" && *feature != "grid" {
+		ErrorExit("'feature' must be: color, hist, grid")
+	}
+	if *gridSize < 1 {
+		ErrorExit("'grid' must be positive")
+	}
+	if *colorDist != "rgb" && *colorDist != "cie76" && *colorDist != "cie94" && *colorDist != "ciede2000" {
+		ErrorExit("'color-dist' must be: rgb, cie76, cie94, ciede2000")
+	}
+	if *maxUses < 0 {
+		ErrorExit("'max-uses' must not be negative")
+	}
+	if *minDist < 0 {
+		ErrorExit("'min-dist' must not be negative")
+	}
+	if *distNorm != "manhattan" && *distNorm != "euclidean" {
+		ErrorExit("'dist-norm' must be: manhattan, euclidean")
+	}
+	if *refine < 0 || *refineTime < 0 {
+		ErrorExit("'refine' and 'refine-time' must not be negative")
+	}
+	if *refinePenalty < 0 {
+		ErrorExit("'refine-penalty' must not be negative")
+	}
+	if *metric != "chi" && *metric != "bhatt" && *metric != "inter" && *metric != "emd" {
+		ErrorExit("'metric' must be: chi, bhatt, inter, emd")
+	}
 
 	var config scheduler.Config = scheduler.Config{}
 	config.InImg = *inImg
 	config.OutImg = *outImg
+	config.Encode = png.EncodeOptions{JPEGQuality: *quality, PNGCompression: *pngCompression, TIFFCompression: *tiffCompression}
+	config.Pyramid = png.PyramidOptions{TileSize: *pyramidTile, Overlap: *pyramidOverlap, Format: *pyramidFormat}
+	config.MaxMem = *maxMem
 	config.TilesDir = *tilesDir
-	config.TileSize = *tileSize
+	config.TileWidth = tileWidth
+	config.TileHeight = tileHeight
+	config.Fit = *fit
+	config.FitFill = fillColor
+	config.SmartMeasure = *smartMeasure
+	config.TileFilter = *tileFilter
+	config.UpscaleFilter = *upscaleFilter
 	config.RunMode = *runMode
 	config.Threads = *threads
 	config.Upscale = *upscale
+	config.OutWidth = *outWidth
+	config.OutHeight = *outHeight
+	config.Megapixels = *megapixels
+	config.KeepAspect = *keepAspect
+	config.Edge = *edge
+	config.Gap = *gap
+	config.GroutColor = groutFill
+	config.GroutTexture = *groutTexture
+	config.Corner = *corner
 	config.Intensity = *intensity
 	config.Blendin = *blendin
+	config.Match = *match
+	config.Feature = *feature
+	config.Metric = *metric
+	config.GridSize = *gridSize
+	config.ColorDist = *colorDist
+	config.TopK = *topK
+	config.Temperature = *temperature
+	config.Seed = *seed
+	config.Layout = *layout
+	config.HexOrient = *hexOrient
+	config.RowOffset = *rowOffset
+	config.VoronoiSeeds = *voronoiSeeds
+
+	// fits the grid to the output before checking the tile size, which the grid may change
+	scheduler.ErrorCheck(scheduler.FitGrid(&config))
+	if config.Gap >= min(config.TileWidth, config.TileHeight) {
+		ErrorExit("'gap' must be smaller than the tile size")
+	}
+
+	config.MinTile = *minTile
+	if config.MinTile == 0 {
+		config.MinTile = max(min(config.TileWidth, config.TileHeight)/4, 1)
+	}
+	config.SplitThreshold = *splitThreshold
+	config.SplitMeasure = *splitMeasure
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	config.MaxUses = *maxUses
+	config.MinDist = *minDist
+	config.DistNorm = *distNorm
+	config.Refine = *refine
+	config.RefineTime = *refineTime
+	config.RefinePenalty = *refinePenalty
 	scheduler.Schedule(&config)
 }
@@ -0,0 +1,368 @@
+package png
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// BMP compression methods
+const (
+	bmpRGB       = 0
+	bmpRLE8      = 1
+	bmpRLE4      = 2
+	bmpBitfields = 3
+	bmpAlpha     = 6
+)
+
+var errBMPFormat = errors.New("png: invalid BMP file")
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:       "bmp",
+		Magic:      []string{"BM"},
+		Extensions: []string{".bmp"},
+		Decode:     DecodeBMP,
+		Encode:     EncodeBMP,
+	})
+}
+
+// bmpMask extracts a channel from a pixel value with a bit field mask, scaled to 8 bits
+type bmpMask struct {
+	mask  uint32
+	shift uint
+	max   uint32
+}
+
+// newBMPMask computes the shift and the maximum value of a bit field mask
+func newBMPMask(mask uint32) bmpMask {
+	field := bmpMask{mask: mask}
+	if mask == 0 {
+		return field
+	}
+	for mask&1 == 0 {
+		mask >>= 1
+		field.shift++
+	}
+	field.max = mask
+	return field
+}
+
+// value returns the channel of the pixel value, or def if the mask is empty
+func (field bmpMask) value(pixel uint32, def uint8) uint8 {
+	if field.max == 0 {
+		return def
+	}
+	return uint8((uint64(pixel&field.mask)>>field.shift*255 + uint64(field.max)/2) / uint64(field.max))
+}
+
+// DecodeBMP reads a BMP image. Palette images of 1 to 8 bits per pixel, uncompressed or run length encoded,
+// and 16, 24 and 32 bits per pixel images, with or without bit field masks, are supported
+func DecodeBMP(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 26 || string(data[:2]) != "BM" {
+		return nil, errBMPFormat
+	}
+	le := binary.LittleEndian
+	offset := int(le.Uint32(data[10:]))
+	headerSize := int(le.Uint32(data[14:]))
+	if headerSize < 12 || 14+headerSize > len(data) {
+		return nil, errBMPFormat
+	}
+	header := data[14 : 14+headerSize]
+
+	var width, height, bpp, compression, colors int
+	paletteEntry := 4
+	if headerSize == 12 {
+		// OS/2 core header with 16 bit sizes and 3 byte palette entries
+		width = int(le.Uint16(header[4:]))
+		height = int(int16(le.Uint16(header[6:])))
+		bpp = int(le.Uint16(header[10:]))
+		paletteEntry = 3
+	} else {
+		if headerSize < 40 {
+			return nil, errBMPFormat
+		}
+		width = int(int32(le.Uint32(header[4:])))
+		height = int(int32(le.Uint32(header[8:])))
+		bpp = int(le.Uint16(header[14:]))
+		compression = int(le.Uint32(header[16:]))
+		colors = int(le.Uint32(header[32:]))
+	}
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	if width <= 0 || height <= 0 || width*height > 1<<28 {
+		return nil, errBMPFormat
+	}
+
+	// bit field masks follow a plain info header, and are part of the larger headers
+	masks := [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+	if bpp == 32 {
+		masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+	}
+	paletteStart := 14 + headerSize
+	if compression == bmpBitfields || compression == bmpAlpha {
+		fields := header[40:]
+		count := 3
+		if compression == bmpAlpha {
+			count = 4
+		}
+		if headerSize == 40 {
+			if paletteStart+4*count > len(data) {
+				return nil, errBMPFormat
+			}
+			fields = data[paletteStart:]
+			paletteStart += 4 * count
+		} else if headerSize >= 56 {
+			count = 4
+		}
+		if len(fields) < 4*count {
+			return nil, errBMPFormat
+		}
+		for i := 0; i < count; i++ {
+			masks[i] = le.Uint32(fields[4*i:])
+		}
+	}
+
+	var palette []color.NRGBA
+	if bpp <= 8 {
+		if colors == 0 || colors > 1<<bpp {
+			colors = 1 << bpp
+		}
+		for i := 0; i < colors; i++ {
+			at := paletteStart + i*paletteEntry
+			if at+3 > len(data) {
+				return nil, errBMPFormat
+			}
+			palette = append(palette, color.NRGBA{data[at+2], data[at+1], data[at], 0xff})
+		}
+	}
+	if offset <= 0 || offset > len(data) {
+		return nil, errBMPFormat
+	}
+	pixels := data[offset:]
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	// row returns the output row of a stored row, as rows are stored bottom-up by default
+	row := func(y int) int {
+		if topDown {
+			return y
+		}
+		return height - 1 - y
+	}
+	switch {
+	case compression == bmpRLE8 || compression == bmpRLE4:
+		indices, err := decodeRLE(pixels, width, height, compression == bmpRLE4)
+		if err != nil {
+			return nil, err
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetNRGBA(x, row(y), paletteColor(palette, indices[y*width+x]))
+			}
+		}
+	case compression != bmpRGB && compression != bmpBitfields && compression != bmpAlpha:
+		return nil, errors.New("png: unsupported BMP compression")
+	case bpp == 1 || bpp == 2 || bpp == 4 || bpp == 8:
+		stride := (bpp*width + 31) / 32 * 4
+		if stride*height > len(pixels) {
+			return nil, errBMPFormat
+		}
+		perByte := 8 / bpp
+		for y := 0; y < height; y++ {
+			line := pixels[y*stride:]
+			for x := 0; x < width; x++ {
+				shift := uint(8 - bpp*(x%perByte+1))
+				index := int(line[x/perByte]>>shift) & (1<<bpp - 1)
+				img.SetNRGBA(x, row(y), paletteColor(palette, index))
+			}
+		}
+	case bpp == 16 || bpp == 24 || bpp == 32:
+		stride := (bpp*width + 31) / 32 * 4
+		if stride*height > len(pixels) {
+			return nil, errBMPFormat
+		}
+		fields := [4]bmpMask{}
+		for i, mask := range masks {
+			fields[i] = newBMPMask(mask)
+		}
+		// a 32 bit image without an alpha mask may still store alpha, which is used unless it is all zero
+		guessAlpha := bpp == 32 && compression == bmpRGB
+		if guessAlpha {
+			fields[3] = newBMPMask(0xff000000)
+		}
+		hasAlpha := false
+		for y := 0; y < height; y++ {
+			line := pixels[y*stride:]
+			for x := 0; x < width; x++ {
+				var c color.NRGBA
+				if bpp == 24 {
+					c = color.NRGBA{line[3*x+2], line[3*x+1], line[3*x], 0xff}
+				} else {
+					var pixel uint32
+					if bpp == 16 {
+						pixel = uint32(le.Uint16(line[2*x:]))
+					} else {
+						pixel = le.Uint32(line[4*x:])
+					}
+					c = color.NRGBA{fields[0].value(pixel, 0), fields[1].value(pixel, 0), fields[2].value(pixel, 0), fields[3].value(pixel, 0xff)}
+				}
+				hasAlpha = hasAlpha || c.A != 0
+				img.SetNRGBA(x, row(y), c)
+			}
+		}
+		if guessAlpha && !hasAlpha {
+			for i := 3; i < len(img.Pix); i += 4 {
+				img.Pix[i] = 0xff
+			}
+		}
+	default:
+		return nil, errors.New("png: unsupported BMP bit depth")
+	}
+	return img, nil
+}
+
+// paletteColor returns the palette color at the index, or black if it is out of the palette
+func paletteColor(palette []color.NRGBA, index int) color.NRGBA {
+	if index < len(palette) {
+		return palette[index]
+	}
+	return color.NRGBA{0, 0, 0, 0xff}
+}
+
+// decodeRLE expands 8 or 4 bit run length encoded pixels into palette indices, with the stored rows in order.
+// Pixels skipped by the encoding keep the index 0
+func decodeRLE(data []byte, width int, height int, nibbles bool) ([]int, error) {
+	indices := make([]int, width*height)
+	x, y := 0, 0
+	set := func(index int) {
+		if x < width && y < height {
+			indices[y*width+x] = index
+		}
+		x++
+	}
+	for i := 0; i+1 < len(data) && y < height; {
+		count, value := int(data[i]), data[i+1]
+		i += 2
+		if count > 0 {
+			for j := 0; j < count; j++ {
+				if nibbles {
+					set(int(value>>(4*uint(1-j%2))) & 0xf)
+				} else {
+					set(int(value))
+				}
+			}
+			continue
+		}
+		switch value {
+		case 0:
+			x, y = 0, y+1
+		case 1:
+			return indices, nil
+		case 2:
+			if i+1 >= len(data) {
+				return nil, errBMPFormat
+			}
+			x, y = x+int(data[i]), y+int(data[i+1])
+			i += 2
+		default:
+			// absolute run of value pixels, padded to an even number of bytes
+			length := int(value)
+			if nibbles {
+				length = (length + 1) / 2
+			}
+			if i+length > len(data) {
+				return nil, errBMPFormat
+			}
+			for j := 0; j < int(value); j++ {
+				if nibbles {
+					set(int(data[i+j/2]>>(4*uint(1-j%2))) & 0xf)
+				} else {
+					set(int(data[i+j]))
+				}
+			}
+			i += length + length%2
+		}
+	}
+	return indices, nil
+}
+
+// EncodeBMP writes an image as an uncompressed BMP, with 24 bits per pixel if it is opaque, and 32 bits
+// per pixel with an alpha mask otherwise
+func EncodeBMP(w io.Writer, img image.Image, options *EncodeOptions) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	opaque := isOpaque(img)
+	bpp, headerSize, compression := 24, 40, bmpRGB
+	if !opaque {
+		bpp, headerSize, compression = 32, 108, bmpBitfields
+	}
+	stride := (bpp*width + 31) / 32 * 4
+	offset := 14 + headerSize
+	size := offset + stride*height
+
+	le := binary.LittleEndian
+	header := make([]byte, offset)
+	copy(header, "BM")
+	le.PutUint32(header[2:], uint32(size))
+	le.PutUint32(header[10:], uint32(offset))
+	le.PutUint32(header[14:], uint32(headerSize))
+	le.PutUint32(header[18:], uint32(width))
+	le.PutUint32(header[22:], uint32(height))
+	le.PutUint16(header[26:], 1)
+	le.PutUint16(header[28:], uint16(bpp))
+	le.PutUint32(header[30:], uint32(compression))
+	le.PutUint32(header[34:], uint32(stride*height))
+	// 72 dpi in pixels per meter
+	le.PutUint32(header[38:], 2835)
+	le.PutUint32(header[42:], 2835)
+	if !opaque {
+		le.PutUint32(header[54:], 0x00ff0000)
+		le.PutUint32(header[58:], 0x0000ff00)
+		le.PutUint32(header[62:], 0x000000ff)
+		le.PutUint32(header[66:], 0xff000000)
+		copy(header[70:], "BGRs")
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	line := make([]byte, stride)
+	for y := height - 1; y >= 0; y-- {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			if opaque {
+				line[3*x], line[3*x+1], line[3*x+2] = c.B, c.G, c.R
+			} else {
+				line[4*x], line[4*x+1], line[4*x+2], line[4*x+3] = c.B, c.G, c.R, c.A
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isOpaque checks if every pixel of an image is fully opaque
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+var (
+	red   = color.NRGBA{0xff, 0, 0, 0xff}
+	green = color.NRGBA{0, 0xff, 0, 0xff}
+	blue  = color.NRGBA{0, 0, 0xff, 0xff}
+	white = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// bmpPalette holds red, green, blue and white as BMP palette entries
+var bmpPalette = []byte{0, 0, 0xff, 0, 0, 0xff, 0, 0, 0xff, 0, 0, 0, 0xff, 0xff, 0xff, 0}
+
+// bmpFile assembles a BMP file with a header of the size, 12 for the OS/2 core header, followed by the table
+// of palette entries or bit field masks and the pixel data. Palettes hold as many colors as the table
+func bmpFile(headerSize int, width int, height int, bpp int, compression int, table []byte, pixels []byte) []byte {
+	le := binary.LittleEndian
+	offset := 14 + headerSize + len(table)
+	data := make([]byte, offset)
+	copy(data, "BM")
+	le.PutUint32(data[2:], uint32(offset+len(pixels)))
+	le.PutUint32(data[10:], uint32(offset))
+	le.PutUint32(data[14:], uint32(headerSize))
+	if headerSize == 12 {
+		le.PutUint16(data[18:], uint16(width))
+		le.PutUint16(data[20:], uint16(height))
+		le.PutUint16(data[22:], 1)
+		le.PutUint16(data[24:], uint16(bpp))
+	} else {
+		le.PutUint32(data[18:], uint32(width))
+		le.PutUint32(data[22:], uint32(height))
+		le.PutUint16(data[26:], 1)
+		le.PutUint16(data[28:], uint16(bpp))
+		le.PutUint32(data[30:], uint32(compression))
+		if bpp <= 8 {
+			le.PutUint32(data[46:], uint32(len(table)/4))
+		}
+	}
+	copy(data[14+headerSize:], table)
+	return append(data, pixels...)
+}
+
+func TestDecodeBMP(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		width  int
+		pixels []color.NRGBA
+	}{
+		{
+			"8 bit palette",
+			bmpFile(40, 3, 2, 8, bmpRGB, bmpPalette, []byte{2, 3, 0, 0, 0, 1, 2, 0}),
+			3, []color.NRGBA{red, green, blue, blue, white, red},
+		},
+		{
+			"4 bit palette top-down",
+			bmpFile(40, 3, -2, 4, bmpRGB, bmpPalette, []byte{0x01, 0x20, 0, 0, 0x23, 0x00, 0, 0}),
+			3, []color.NRGBA{red, green, blue, blue, white, red},
+		},
+		{
+			"1 bit palette",
+			bmpFile(40, 3, 1, 1, bmpRGB, bmpPalette[:8], []byte{0xa0, 0, 0, 0}),
+			3, []color.NRGBA{green, red, green},
+		},
+		{
+			"RLE8",
+			bmpFile(40, 3, 2, 8, bmpRLE8, bmpPalette, []byte{2, 2, 1, 1, 0, 0, 0, 3, 0, 1, 2, 0, 0, 1}),
+			3, []color.NRGBA{red, green, blue, blue, blue, green},
+		},
+		{
+			"RLE8 delta",
+			bmpFile(40, 3, 2, 8, bmpRLE8, bmpPalette, []byte{1, 3, 0, 2, 1, 1, 2, 2, 0, 1}),
+			3, []color.NRGBA{red, red, blue, white, red, red},
+		},
+		{
+			"RLE4",
+			bmpFile(40, 3, 2, 4, bmpRLE4, bmpPalette, []byte{3, 0x12, 0, 0, 0, 3, 0x01, 0x20, 0, 1}),
+			3, []color.NRGBA{red, green, blue, green, blue, green},
+		},
+		{
+			"16 bit default masks",
+			bmpFile(40, 3, 1, 16, bmpRGB, nil, []byte{0x00, 0x7c, 0xe0, 0x03, 0x1f, 0x00, 0, 0}),
+			3, []color.NRGBA{red, green, blue},
+		},
+		{
+			"16 bit bit fields",
+			bmpFile(40, 2, 1, 16, bmpBitfields, []byte{0, 0xf8, 0, 0, 0xe0, 0x07, 0, 0, 0x1f, 0, 0, 0}, []byte{0xe0, 0x07, 0x10, 0x00}),
+			2, []color.NRGBA{green, {0, 0, 0x84, 0xff}},
+		},
+		{
+			"24 bit core header",
+			bmpFile(12, 1, 2, 24, bmpRGB, nil, []byte{30, 20, 10, 0, 0xff, 0xff, 0xff, 0}),
+			1, []color.NRGBA{white, {10, 20, 30, 0xff}},
+		},
+		{
+			"32 bit without alpha",
+			bmpFile(40, 2, 1, 32, bmpRGB, nil, []byte{0xff, 0, 0, 0, 0, 0, 0xff, 0}),
+			2, []color.NRGBA{blue, red},
+		},
+		{
+			"32 bit with alpha",
+			bmpFile(40, 2, 1, 32, bmpRGB, nil, []byte{0xff, 0, 0, 0x80, 0, 0, 0xff, 0}),
+			2, []color.NRGBA{{0, 0, 0xff, 0x80}, {0xff, 0, 0, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := DecodeBMP(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			height := len(test.pixels) / test.width
+			if size := img.Bounds().Size(); size.X != test.width || size.Y != height {
+				t.Fatalf("size %v, want %dx%d", size, test.width, height)
+			}
+			for i, want := range test.pixels {
+				if got := img.At(i%test.width, i/test.width); got != want {
+					t.Errorf("pixel (%d, %d) is %v, want %v", i%test.width, i/test.width, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBMPInvalid(t *testing.T) {
+	valid := bmpFile(40, 3, 2, 8, bmpRGB, bmpPalette, []byte{2, 3, 0, 0, 0, 1, 2, 0})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated header", valid[:30]},
+		{"truncated pixels", valid[:len(valid)-2]},
+		{"zero width", bmpFile(40, 0, 2, 24, bmpRGB, nil, make([]byte, 8))},
+		{"unsupported compression", bmpFile(40, 1, 1, 24, 4, nil, make([]byte, 4))},
+		{"unsupported bit depth", bmpFile(40, 1, 1, 12, bmpRGB, nil, make([]byte, 4))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeBMP(bytes.NewReader(test.data)); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package png
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFormat is returned when no codec recognizes an image file or an output extension
+var ErrUnknownFormat = errors.New("png: unknown image format")
+
+// EncodeOptions holds the settings of the encoders which have any. JPEGQuality ranges from 1 to 100,
+// PNGCompression is one of the PNGCompressions and TIFFCompression is one of the TIFFCompressions
+type EncodeOptions struct {
+	JPEGQuality     int
+	PNGCompression  string
+	TIFFCompression string
+}
+
+// DefaultEncodeOptions are the encoder settings used by Save
+var DefaultEncodeOptions = EncodeOptions{JPEGQuality: jpeg.DefaultQuality, PNGCompression: "default", TIFFCompression: "lzw"}
+
+// PNGCompressions maps the names of the PNG compression levels to the levels
+var PNGCompressions = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// Codec decodes and encodes an image format. Magic holds the possible leading bytes of a file of the
+// format, where '?' matches any byte, and Extensions holds the lower case file extensions it is saved as.
+// A codec without Encode can only be loaded, and a codec without DecodeConfig is decoded whole to find its size
+type Codec struct {
+	Name         string
+	Magic        []string
+	Extensions   []string
+	Decode       func(r io.Reader) (image.Image, error)
+	DecodeConfig func(r io.Reader) (image.Config, error)
+	Encode       func(w io.Writer, img image.Image, options *EncodeOptions) error
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    []*Codec
+)
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:         "png",
+		Magic:        []string{"\x89PNG\r\n\x1a\n"},
+		Extensions:   []string{".png"},
+		Decode:       png.Decode,
+		DecodeConfig: png.DecodeConfig,
+		Encode: func(w io.Writer, img image.Image, options *EncodeOptions) error {
+			encoder := png.Encoder{CompressionLevel: PNGCompressions[options.PNGCompression]}
+			return encoder.Encode(w, img)
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:         "jpeg",
+		Magic:        []string{"\xff\xd8"},
+		Extensions:   []string{".jpg", ".jpeg"},
+		Decode:       jpeg.Decode,
+		DecodeConfig: jpeg.DecodeConfig,
+		Encode: func(w io.Writer, img image.Image, options *EncodeOptions) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: options.JPEGQuality})
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:         "gif",
+		Magic:        []string{"GIF87a", "GIF89a"},
+		Extensions:   []string{".gif"},
+		Decode:       gif.Decode,
+		DecodeConfig: gif.DecodeConfig,
+		Encode: func(w io.Writer, img image.Image, options *EncodeOptions) error {
+			return gif.Encode(w, img, nil)
+		},
+	})
+}
+
+// RegisterCodec adds a codec, which is detected before the codecs registered earlier
+func RegisterCodec(codec *Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs = append([]*Codec{codec}, codecs...)
+}
+
+// matchMagic checks if the header starts with the magic bytes
+func matchMagic(magic string, header []byte) bool {
+	if len(header) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != header[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DetectCodec finds the codec whose magic bytes start the header
+func DetectCodec(header []byte) (*Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, codec := range codecs {
+		for _, magic := range codec.Magic {
+			if matchMagic(magic, header) {
+				return codec, nil
+			}
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+// CodecForPath finds the codec which encodes files with the extension of the path
+func CodecForPath(filePath string) (*Codec, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, codec := range codecs {
+		if codec.Encode == nil {
+			continue
+		}
+		for _, codecExt := range codec.Extensions {
+			if codecExt == ext {
+				return codec, nil
+			}
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+// detectReader detects the codec of the image read by r, returning a reader which still starts with the header
+func detectReader(r io.Reader) (*Codec, io.Reader, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(16)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	codec, err := DetectCodec(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return codec, reader, nil
+}
+
+// Decode reads an image in any registered format, detected from its leading bytes
+func Decode(r io.Reader) (image.Image, error) {
+	codec, reader, err := detectReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(reader)
+}
+
+// DecodeSize reads the width and height of an image in any registered format, only decoding its header
+// if the codec can
+func DecodeSize(r io.Reader) (int, int, error) {
+	codec, reader, err := detectReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if codec.DecodeConfig != nil {
+		config, err := codec.DecodeConfig(reader)
+		return config.Width, config.Height, err
+	}
+	img, err := codec.Decode(reader)
+	if err != nil {
+		return 0, 0, err
+	}
+	return img.Bounds().Dx(), img.Bounds().Dy(), nil
+}
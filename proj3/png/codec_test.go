@@ -0,0 +1,113 @@
+package png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// testImage makes an image of the size with a different color in every pixel, with 8 or 16 bit samples.
+// alpha makes the pixels partly transparent
+func testImage(width int, height int, depth int, alpha bool) image.Image {
+	var img draw.Image = image.NewNRGBA64(image.Rect(0, 0, width, height))
+	if depth == 8 {
+		img = image.NewNRGBA(img.Bounds())
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA64{uint16(x * 9001), uint16(y * 7919), uint16((x + y) * 4099), 0xffff}
+			if alpha {
+				c.A = uint16((x*y*1237 + 257) % 65536)
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// sameImage checks that two images have the same size and the same premultiplied colors
+func sameImage(t *testing.T, got image.Image, want image.Image) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("size %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	offset := got.Bounds().Min.Sub(want.Bounds().Min)
+	for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+		for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+			r0, g0, b0, a0 := got.At(x+offset.X, y+offset.Y).RGBA()
+			r1, g1, b1, a1 := want.At(x, y).RGBA()
+			if [4]uint32{r0, g0, b0, a0} != [4]uint32{r1, g1, b1, a1} {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, [4]uint32{r0, g0, b0, a0}, [4]uint32{r1, g1, b1, a1})
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		codec       string
+		compression string
+		depth       int
+		alpha       bool
+	}{
+		{"bmp", "bmp", "", 8, false},
+		{"bmp alpha", "bmp", "", 8, true},
+		{"tiff none", "tiff", "none", 8, false},
+		{"tiff packbits", "tiff", "packbits", 8, false},
+		{"tiff lzw", "tiff", "lzw", 8, false},
+		{"tiff lzw 16 bit", "tiff", "lzw", 16, false},
+		{"tiff packbits 16 bit", "tiff", "packbits", 16, false},
+		{"tiff none alpha", "tiff", "none", 8, true},
+		{"tiff lzw alpha", "tiff", "lzw", 8, true},
+		{"tiff lzw 16 bit alpha", "tiff", "lzw", 16, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, err := CodecForPath("out." + test.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options := DefaultEncodeOptions
+			options.TIFFCompression = test.compression
+			// an odd width leaves padding at the end of the rows, and the height spans several TIFF strips
+			img := testImage(37, 150, test.depth, test.alpha)
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, img, &options); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameImage(t, decoded, img)
+		})
+	}
+}
+
+func TestDecodeSize(t *testing.T) {
+	for _, codec := range []string{"png", "jpg", "gif", "bmp", "tiff"} {
+		t.Run(codec, func(t *testing.T) {
+			c, err := CodecForPath("out." + codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := c.Encode(&buf, testImage(13, 7, 8, false), &DefaultEncodeOptions); err != nil {
+				t.Fatal(err)
+			}
+			width, height, err := DecodeSize(&buf)
+			if err != nil || width != 13 || height != 7 {
+				t.Fatalf("DecodeSize = %d, %d, %v, want 13, 7", width, height, err)
+			}
+		})
+	}
+}
+
+func TestDecodeUnknown(t *testing.T) {
+	if _, err := Decode(bytes.NewReader([]byte("not an image"))); err != ErrUnknownFormat {
+		t.Fatalf("Decode error %v, want %v", err, ErrUnknownFormat)
+	}
+}
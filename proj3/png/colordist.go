@@ -0,0 +1,112 @@
+package png
+
+import "math"
+
+// ColorDistance represents a perceptual distance between two CIE Lab colors
+type ColorDistance func(lab1 [3]float64, lab2 [3]float64) float64
+
+// ColorDistances lists the available color distances by name
+var ColorDistances = map[string]ColorDistance{
+	"cie76":     CIE76,
+	"cie94":     CIE94,
+	"ciede2000": CIEDE2000,
+}
+
+// CIE76 computes the CIE 1976 color difference, which is the euclidean distance in Lab
+func CIE76(lab1 [3]float64, lab2 [3]float64) float64 {
+	dL := lab1[0] - lab2[0]
+	da := lab1[1] - lab2[1]
+	db := lab1[2] - lab2[2]
+	return math.Sqrt(dL*dL + da*da + db*db)
+}
+
+// CIE94 computes the CIE 1994 color difference with the graphic arts weights
+func CIE94(lab1 [3]float64, lab2 [3]float64) float64 {
+	const kL, k1, k2 = 1.0, 0.045, 0.015
+	dL := lab1[0] - lab2[0]
+	c1 := math.Hypot(lab1[1], lab1[2])
+	c2 := math.Hypot(lab2[1], lab2[2])
+	dC := c1 - c2
+	da := lab1[1] - lab2[1]
+	db := lab1[2] - lab2[2]
+	dH2 := math.Max(0, da*da+db*db-dC*dC)
+	sC := 1 + k1*c1
+	sH := 1 + k2*c1
+	return math.Sqrt((dL/kL)*(dL/kL) + (dC/sC)*(dC/sC) + dH2/(sH*sH))
+}
+
+// CIEDE2000 computes the CIE 2000 color difference
+// reference: https://hajim.rochester.edu/ece/sites/gsharma/ciede2000/ciede2000noteCRNA.pdf
+func CIEDE2000(lab1 [3]float64, lab2 [3]float64) float64 {
+	L1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	L2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+math.Pow(25, 7))))
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dLp := L2 - L1
+	dCp := c2p - c1p
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(degToRad(dhp/2))
+
+	lBarp := (L1 + L2) / 2
+	cBarp := (c1p + c2p) / 2
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hBarp /= 2
+		} else if h1p+h2p < 360 {
+			hBarp = (hBarp + 360) / 2
+		} else {
+			hBarp = (hBarp - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(degToRad(hBarp-30)) + 0.24*math.Cos(degToRad(2*hBarp)) +
+		0.32*math.Cos(degToRad(3*hBarp+6)) - 0.20*math.Cos(degToRad(4*hBarp-63))
+	dTheta := 30 * math.Exp(-((hBarp-275)/25)*((hBarp-275)/25))
+	cBarp7 := math.Pow(cBarp, 7)
+	rC := 2 * math.Sqrt(cBarp7/(cBarp7+math.Pow(25, 7)))
+	lBarp50 := (lBarp - 50) * (lBarp - 50)
+	sL := 1 + 0.015*lBarp50/math.Sqrt(20+lBarp50)
+	sC := 1 + 0.045*cBarp
+	sH := 1 + 0.015*cBarp*t
+	rT := -math.Sin(degToRad(2*dTheta)) * rC
+
+	termL := dLp / sL
+	termC := dCp / sC
+	termH := dHp / sH
+	return math.Sqrt(termL*termL + termC*termC + termH*termH + rT*termC*termH)
+}
+
+// hueAngle computes the hue angle in degrees (0 - 360) from the b and a components
+func hueAngle(b float64, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// degToRad converts degrees to radians
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
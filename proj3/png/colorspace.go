@@ -0,0 +1,163 @@
+package png
+
+import (
+	"image"
+	"math"
+)
+
+// D65 reference white point in XYZ
+const (
+	whiteX = 0.95047
+	whiteY = 1.00000
+	whiteZ = 1.08883
+)
+
+// SRGBToLinear converts a gamma encoded sRGB component (0.0 - 1.0) to linear light
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// LinearToSRGB converts a linear light component to gamma encoded sRGB (0.0 - 1.0)
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// LinearToXYZ converts linear RGB components to CIE XYZ under D65
+func LinearToXYZ(r float64, g float64, b float64) (float64, float64, float64) {
+	x := 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := 0.0193339*r + 0.1191920*g + 0.9503041*b
+	return x, y, z
+}
+
+// XYZToLinear converts CIE XYZ under D65 to linear RGB components
+func XYZToLinear(x float64, y float64, z float64) (float64, float64, float64) {
+	r := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	b := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return r, g, b
+}
+
+// labF is the nonlinear compression used by the XYZ to Lab conversion
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27.0*t + 16) / 116
+}
+
+// XYZToLab converts CIE XYZ under D65 to CIE Lab
+func XYZToLab(x float64, y float64, z float64) [3]float64 {
+	fx := labF(x / whiteX)
+	fy := labF(y / whiteY)
+	fz := labF(z / whiteZ)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// labFInv is the inverse of labF
+func labFInv(t float64) float64 {
+	if t > 6.0/29.0 {
+		return t * t * t
+	}
+	return (116*t - 16) * 27.0 / 24389.0
+}
+
+// LabToXYZ converts CIE Lab to CIE XYZ under D65
+func LabToXYZ(lab [3]float64) (float64, float64, float64) {
+	fy := (lab[0] + 16) / 116
+	fx := fy + lab[1]/500
+	fz := fy - lab[2]/200
+	return whiteX * labFInv(fx), whiteY * labFInv(fy), whiteZ * labFInv(fz)
+}
+
+// RGBA64ToLab converts 16-bit sRGB components, as returned by color.Color.RGBA, to CIE Lab
+func RGBA64ToLab(r uint32, g uint32, b uint32) [3]float64 {
+	lr := SRGBToLinear(float64(r) / 0xffff)
+	lg := SRGBToLinear(float64(g) / 0xffff)
+	lb := SRGBToLinear(float64(b) / 0xffff)
+	return XYZToLab(LinearToXYZ(lr, lg, lb))
+}
+
+// LabToRGBA64 converts CIE Lab to 16-bit sRGB components, clipping colors outside the sRGB gamut
+func LabToRGBA64(lab [3]float64) (uint16, uint16, uint16) {
+	lr, lg, lb := XYZToLinear(LabToXYZ(lab))
+	toUint16 := func(v float64) uint16 {
+		return uint16(math.Round(math.Min(math.Max(LinearToSRGB(v), 0), 1) * 0xffff))
+	}
+	return toUint16(lr), toUint16(lg), toUint16(lb)
+}
+
+// MeanLab computes the mean CIE Lab color of the image
+func (img *Image) MeanLab() [3]float64 {
+	return img.MeanLabMask(nil)
+}
+
+// MeanLabMask computes the mean CIE Lab color of the pixels covered by the mask
+func (img *Image) MeanLabMask(mask *image.Alpha) [3]float64 {
+	return img.meanLab(img.Bounds(), mask)
+}
+
+// meanLab computes the mean CIE Lab color of the pixels inside the bounds covered by the mask.
+// Returns zero if no pixel is covered
+func (img *Image) meanLab(bounds image.Rectangle, mask *image.Alpha) [3]float64 {
+	var mean [3]float64
+	var totalPixels float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !Covered(mask, x, y) {
+				continue
+			}
+			r, g, b, _ := img.At(x, y).RGBA()
+			lab := RGBA64ToLab(r, g, b)
+			for i := 0; i < 3; i++ {
+				mean[i] += lab[i]
+			}
+			totalPixels++
+		}
+	}
+	if totalPixels == 0 {
+		return mean
+	}
+	for i := 0; i < 3; i++ {
+		mean[i] /= totalPixels
+	}
+	return mean
+}
+
+// GridSignature divides the image into an n x n grid and returns the mean Lab color of each grid cell, row by row
+func (img *Image) GridSignature(n int) []float64 {
+	return img.GridSignatureMask(n, nil)
+}
+
+// GridSignatureMask is GridSignature counting only the pixels covered by the mask.
+// Grid cells without covered pixels get the mean color of the whole mask
+func (img *Image) GridSignatureMask(n int, mask *image.Alpha) []float64 {
+	bounds := img.Bounds()
+	whole := img.meanLab(bounds, mask)
+	signature := make([]float64, 0, 3*n*n)
+	for gy := 0; gy < n; gy++ {
+		for gx := 0; gx < n; gx++ {
+			cell := image.Rect(
+				bounds.Min.X+gx*bounds.Dx()/n,
+				bounds.Min.Y+gy*bounds.Dy()/n,
+				bounds.Min.X+(gx+1)*bounds.Dx()/n,
+				bounds.Min.Y+(gy+1)*bounds.Dy()/n,
+			)
+			if cell.Empty() {
+				cell = image.Rect(cell.Min.X, cell.Min.Y, cell.Min.X+1, cell.Min.Y+1).Intersect(bounds)
+			}
+			mean := whole
+			if coveredIn(mask, cell) {
+				mean = img.meanLab(cell, mask)
+			}
+			signature = append(signature, mean[:]...)
+		}
+	}
+	return signature
+}
@@ -8,17 +8,28 @@ import (
 )
 
 func (img *Image) Histogram() [3][256]float64 {
+	return img.HistogramMask(nil)
+}
+
+func (img *Image) HistogramMask(mask *image.Alpha) [3][256]float64 {
 	bounds := (*img).Bounds()
 	var hist [3][256]float64
+	var totalPixels float64
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !Covered(mask, x, y) {
+				continue
+			}
 			r, g, b, _ := (*img).At(x, y).RGBA()
 			hist[0][r>>8]++
 			hist[1][g>>8]++
 			hist[2][b>>8]++
+			totalPixels++
 		}
 	}
-	totalPixels := float64(bounds.Dx() * bounds.Dy())
+	if totalPixels == 0 {
+		return hist
+	}
 
 	for i := 0; i < 3; i++ {
 		for j := 0; j < 256; j++ {
@@ -30,17 +41,28 @@ func (img *Image) Histogram() [3][256]float64 {
 }
 
 func (img *Image) CDF() [3][256]float64 {
+	return img.CDFMask(nil)
+}
+
+func (img *Image) CDFMask(mask *image.Alpha) [3][256]float64 {
 	bounds := (*img).Bounds()
 	var histCDF [3][256]float64
+	var totalPixels float64
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !Covered(mask, x, y) {
+				continue
+			}
 			r, g, b, _ := (*img).At(x, y).RGBA()
 			histCDF[0][r>>8]++
 			histCDF[1][g>>8]++
 			histCDF[2][b>>8]++
+			totalPixels++
 		}
 	}
-	totalPixels := float64(bounds.Dx() * bounds.Dy())
+	if totalPixels == 0 {
+		return histCDF
+	}
 
 	for i := 0; i < 3; i++ {
 		var sum float64
@@ -58,19 +80,33 @@ func (img *Image) MapPixels(pixels [3][256]float64) *Image {
 	for x := 0; x < bounds.Dx(); x++ {
 		for y := 0; y < bounds.Dy(); y++ {
 			r, g, b, a := (*img).At(x+bounds.Min.X, y+bounds.Min.Y).RGBA()
-			r = uint32(math.Round(pixels[0][r>>8])) * 256
-			g = uint32(math.Round(pixels[1][g>>8])) * 256
-			b = uint32(math.Round(pixels[2][b>>8])) * 256
-			newImg.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+			newImg.Set(x, y, color.RGBA64{lookup(&pixels[0], r), lookup(&pixels[1], g), lookup(&pixels[2], b), uint16(a)})
 		}
 	}
 	return newImg
 }
 
+// lookup maps a 16 bit value through an 8 bit table, interpolating between the entries so that the
+// precision of 16 bit images is kept
+func lookup(table *[256]float64, value uint32) uint16 {
+	pos := float64(value) / 257
+	i := int(pos)
+	if i >= 255 {
+		return uint16(math.Max(0, math.Min(65535, math.Round(table[255]*257))))
+	}
+	frac := pos - float64(i)
+	mapped := (table[i]*(1-frac) + table[i+1]*frac) * 257
+	return uint16(math.Max(0, math.Min(65535, math.Round(mapped))))
+}
+
 func (img *Image) ColorTransfer(imgRef *Image) *Image {
+	return img.ColorTransferMask(imgRef, nil)
+}
+
+func (img *Image) ColorTransferMask(imgRef *Image, mask *image.Alpha) *Image {
 	var matchImg *Image
-	inCDF := img.CDF()
-	refCDF := imgRef.CDF()
+	inCDF := img.CDFMask(mask)
+	refCDF := imgRef.CDFMask(mask)
 	var pixels [3][256]float64
 	var newPixels [3][256]float64
 	for i := 0; i < 3; i++ {
@@ -101,10 +137,15 @@ func ColorBlend(srcColor *color.RGBA64, dstColor *color.RGBA64, dstWeight float6
 }
 
 func (img *Image) Resize(width int, height int) *Image {
-	newImg := NewImage(width, height)
+	return img.ResizeRows(width, height, 0, height)
+}
+
+// ResizeRows computes the rows from top to bottom of the image resized by Resize, keeping their position in the bounds
+func (img *Image) ResizeRows(width int, height int, top int, bottom int) *Image {
+	newImg := &Image{image.NewRGBA64(image.Rect(0, top, width, bottom))}
 	boundsOri := img.Bounds()
 	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
+		for y := top; y < bottom; y++ {
 			x0 := int(float64(x) * float64(boundsOri.Dx()) / float64(width))
 			y0 := int(float64(y) * float64(boundsOri.Dy()) / float64(height))
 			r, g, b, a := img.At(x0, y0).RGBA()
@@ -114,6 +155,18 @@ func (img *Image) Resize(width int, height int) *Image {
 	return newImg
 }
 
+// Rotate90 turns the image by 90 degrees clockwise
+func (img *Image) Rotate90() *Image {
+	bounds := img.Bounds()
+	newImg := NewImage(bounds.Dy(), bounds.Dx())
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			newImg.Set(bounds.Dy()-1-y, x, img.At(x+bounds.Min.X, y+bounds.Min.Y))
+		}
+	}
+	return newImg
+}
+
 func (img *Image) Subsize(bounds image.Rectangle) *Image {
 	newImg := NewImage(bounds.Dx(), bounds.Dy())
 	for x := 0; x < bounds.Dx(); x++ {
@@ -124,3 +177,32 @@ func (img *Image) Subsize(bounds image.Rectangle) *Image {
 	}
 	return newImg
 }
+
+func (img *Image) MeanColor() [3]float64 {
+	return img.MeanColorMask(nil)
+}
+
+func (img *Image) MeanColorMask(mask *image.Alpha) [3]float64 {
+	bounds := img.Bounds()
+	var mean [3]float64
+	var totalPixels float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !Covered(mask, x, y) {
+				continue
+			}
+			r, g, b, _ := img.At(x, y).RGBA()
+			mean[0] += float64(r)
+			mean[1] += float64(g)
+			mean[2] += float64(b)
+			totalPixels++
+		}
+	}
+	if totalPixels == 0 {
+		return mean
+	}
+	for i := 0; i < 3; i++ {
+		mean[i] /= totalPixels * 0xffff
+	}
+	return mean
+}
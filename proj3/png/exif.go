@@ -0,0 +1,148 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// EXIF tags read into the metadata
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+	tagPixelXDimension  = 0xa002
+	tagPixelYDimension  = 0xa003
+)
+
+// Metadata holds the EXIF fields of an image file. Orientation is the EXIF orientation from 1 to 8, which is 1
+// for upright images. CaptureTime is the original date and time, without a time zone, and is zero if it is
+// unknown. Width and Height are the size of the upright image
+type Metadata struct {
+	Orientation int
+	CaptureTime time.Time
+	Width       int
+	Height      int
+}
+
+// ReadMetadata reads the EXIF fields of a JPEG or TIFF file. Missing fields keep their zero value, except for
+// the orientation, which defaults to upright. The size is the EXIF pixel dimensions, turned upright
+func ReadMetadata(data []byte) *Metadata {
+	meta := &Metadata{Orientation: 1}
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		parseEXIF(meta, data)
+	} else if exif := findEXIF(data); exif != nil {
+		parseEXIF(meta, exif)
+	}
+	return meta
+}
+
+// findEXIF returns the TIFF structure held by the APP1 EXIF segment of a JPEG file, or nil if it has none
+func findEXIF(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		// the metadata segments come before the start of scan
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// parseEXIF reads the metadata fields from an EXIF TIFF structure, ignoring the fields it cannot read
+func parseEXIF(meta *Metadata, exif []byte) {
+	if len(exif) < 8 {
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if exif[0] == 'M' {
+		order = binary.BigEndian
+	}
+	fields, err := readIFD(exif, order, int(order.Uint32(exif[4:])))
+	if err != nil {
+		return
+	}
+	if orientation := fields[tagOrientation]; len(orientation) > 0 && orientation[0] >= 1 && orientation[0] <= 8 {
+		meta.Orientation = int(orientation[0])
+	}
+	captureTime := fields[tagDateTime]
+	if pointer := fields[tagExifIFD]; len(pointer) > 0 {
+		if sub, err := readIFD(exif, order, int(pointer[0])); err == nil {
+			if original := sub[tagDateTimeOriginal]; len(original) > 0 {
+				captureTime = original
+			}
+			if len(sub[tagPixelXDimension]) > 0 && len(sub[tagPixelYDimension]) > 0 {
+				meta.Width, meta.Height = int(sub[tagPixelXDimension][0]), int(sub[tagPixelYDimension][0])
+				if meta.Orientation >= 5 {
+					meta.Width, meta.Height = meta.Height, meta.Width
+				}
+			}
+		}
+	}
+	if len(captureTime) > 0 {
+		text := make([]byte, len(captureTime))
+		for i, c := range captureTime {
+			text[i] = byte(c)
+		}
+		value := strings.TrimRight(string(text), "\x00 ")
+		if t, err := time.Parse("2006:01:02 15:04:05", value); err == nil {
+			meta.CaptureTime = t
+		}
+	}
+}
+
+// Orient turns an image stored with the EXIF orientation upright
+func (img *Image) Orient(orientation int) *Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newImg := NewImage(width, height)
+	if orientation >= 5 {
+		newImg = NewImage(height, width)
+	}
+	newBounds := newImg.Bounds()
+	for x := 0; x < newBounds.Dx(); x++ {
+		for y := 0; y < newBounds.Dy(); y++ {
+			// finds the stored pixel shown at the upright position
+			var srcX, srcY int
+			switch orientation {
+			case 2:
+				srcX, srcY = width-1-x, y
+			case 3:
+				srcX, srcY = width-1-x, height-1-y
+			case 4:
+				srcX, srcY = x, height-1-y
+			case 5:
+				srcX, srcY = y, x
+			case 6:
+				srcX, srcY = y, height-1-x
+			case 7:
+				srcX, srcY = width-1-y, height-1-x
+			case 8:
+				srcX, srcY = width-1-y, x
+			}
+			newImg.Set(x, y, img.At(srcX+bounds.Min.X, srcY+bounds.Min.Y))
+		}
+	}
+	return newImg
+}
@@ -0,0 +1,170 @@
+package png
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// labelled makes an image of the size whose pixel at (x, y) is labelled by its index y*width+x
+func labelled(width int, height int) *Image {
+	img := NewImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint16((y*width + x + 1) * 1000)
+			img.Set(x, y, color.RGBA64{v, v, v, 0xffff})
+		}
+	}
+	return img
+}
+
+func TestOrient(t *testing.T) {
+	// the stored 3x2 image is
+	//   0 1 2
+	//   3 4 5
+	tests := []struct {
+		orientation int
+		width       int
+		labels      []int
+	}{
+		{0, 3, []int{0, 1, 2, 3, 4, 5}},
+		{1, 3, []int{0, 1, 2, 3, 4, 5}},
+		{2, 3, []int{2, 1, 0, 5, 4, 3}},
+		{3, 3, []int{5, 4, 3, 2, 1, 0}},
+		{4, 3, []int{3, 4, 5, 0, 1, 2}},
+		{5, 2, []int{0, 3, 1, 4, 2, 5}},
+		{6, 2, []int{3, 0, 4, 1, 5, 2}},
+		{7, 2, []int{5, 2, 4, 1, 3, 0}},
+		{8, 2, []int{2, 5, 1, 4, 0, 3}},
+		{9, 3, []int{0, 1, 2, 3, 4, 5}},
+	}
+	stored := labelled(3, 2)
+	for _, test := range tests {
+		img := stored.Orient(test.orientation)
+		height := len(test.labels) / test.width
+		if size := img.Bounds().Size(); size.X != test.width || size.Y != height {
+			t.Errorf("orientation %d: size %v, want %dx%d", test.orientation, size, test.width, height)
+			continue
+		}
+		for i, label := range test.labels {
+			x, y := i%test.width, i/test.width
+			if got, want := img.At(x, y), stored.At(label%3, label/3); got != want {
+				t.Errorf("orientation %d: pixel (%d, %d) is %v, want %v", test.orientation, x, y, got, want)
+			}
+		}
+	}
+}
+
+// exifTIFF assembles an EXIF TIFF structure in the byte order with the orientation, left out if it is 0,
+// and a sub-directory holding the original capture time and the stored pixel dimensions of 30x20
+func exifTIFF(order binary.AppendByteOrder, orientation int) []byte {
+	magic := "II*\x00"
+	if order == binary.BigEndian {
+		magic = "MM\x00*"
+	}
+	entry := func(data []byte, tag uint16, kind uint16, count int, value uint32) []byte {
+		data = order.AppendUint16(data, tag)
+		data = order.AppendUint16(data, kind)
+		data = order.AppendUint32(data, uint32(count))
+		if kind == tiffShort {
+			return order.AppendUint16(order.AppendUint16(data, uint16(value)), 0)
+		}
+		return order.AppendUint32(data, value)
+	}
+	count := 1
+	if orientation > 0 {
+		count++
+	}
+	sub := 8 + 2 + 12*count + 4
+	text := sub + 2 + 12*3 + 4
+
+	data := order.AppendUint32([]byte(magic), 8)
+	data = order.AppendUint16(data, uint16(count))
+	if orientation > 0 {
+		data = entry(data, tagOrientation, tiffShort, 1, uint32(orientation))
+	}
+	data = entry(data, tagExifIFD, tiffLong, 1, uint32(sub))
+	data = order.AppendUint32(data, 0)
+	data = order.AppendUint16(data, 3)
+	data = entry(data, tagDateTimeOriginal, tiffASCII, 20, uint32(text))
+	data = entry(data, tagPixelXDimension, tiffLong, 1, 30)
+	data = entry(data, tagPixelYDimension, tiffShort, 1, 20)
+	data = order.AppendUint32(data, 0)
+	return append(data, "2021:06:15 10:20:30\x00"...)
+}
+
+// jpegWithEXIF wraps an EXIF TIFF structure into the APP1 segment of a JPEG file, after an APP0 segment
+func jpegWithEXIF(exif []byte) []byte {
+	data := []byte("\xff\xd8\xff\xe0\x00\x07JFIF\x00")
+	data = append(data, 0xff, 0xe1)
+	data = binary.BigEndian.AppendUint16(data, uint16(2+6+len(exif)))
+	data = append(data, "Exif\x00\x00"...)
+	data = append(data, exif...)
+	return append(data, 0xff, 0xda, 0x00, 0x02, 0xff, 0xd9)
+}
+
+func TestReadMetadata(t *testing.T) {
+	captured := time.Date(2021, 6, 15, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		data []byte
+		want Metadata
+	}{
+		{"jpeg little endian", jpegWithEXIF(exifTIFF(binary.LittleEndian, 3)), Metadata{3, captured, 30, 20}},
+		{"jpeg big endian", jpegWithEXIF(exifTIFF(binary.BigEndian, 6)), Metadata{6, captured, 20, 30}},
+		{"tiff big endian", exifTIFF(binary.BigEndian, 8), Metadata{8, captured, 20, 30}},
+		{"missing orientation", jpegWithEXIF(exifTIFF(binary.LittleEndian, 0)), Metadata{1, captured, 30, 20}},
+		{"invalid orientation", jpegWithEXIF(exifTIFF(binary.BigEndian, 9)), Metadata{1, captured, 30, 20}},
+		{"no exif", []byte("\xff\xd8\xff\xe0\x00\x07JFIF\x00\xff\xda\x00\x02"), Metadata{Orientation: 1}},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), Metadata{Orientation: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ReadMetadata(test.data); *got != test.want {
+				t.Fatalf("ReadMetadata = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+// TestReadMetadataMalformed checks that truncated and garbage metadata is ignored without panicking
+func TestReadMetadataMalformed(t *testing.T) {
+	valid := jpegWithEXIF(exifTIFF(binary.BigEndian, 6))
+	for end := range valid {
+		meta := ReadMetadata(valid[:end])
+		if meta.Orientation < 1 || meta.Orientation > 8 {
+			t.Fatalf("orientation %d read from %d bytes", meta.Orientation, end)
+		}
+	}
+
+	// corrupt sets the most significant byte of a little endian value of a valid EXIF structure with orientation 6,
+	// whose sub-directory pointer is at 30 and whose capture time offset is at 48
+	corrupt := func(at int) []byte {
+		data := exifTIFF(binary.LittleEndian, 6)
+		data[at+3] = 0x7f
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"segment past the end", []byte("\xff\xd8\xff\xe1\xff\xffExif\x00\x00")},
+		{"segment too short", []byte("\xff\xd8\xff\xe1\x00\x01")},
+		{"garbage after the marker", []byte("\xff\xd8\x12\x34\x56\x78")},
+		{"garbage exif", jpegWithEXIF([]byte("II*\x00garbage garbage garbage"))},
+		{"directory past the end", jpegWithEXIF([]byte("MM\x00*\x7f\xff\xff\xff"))},
+		{"too many entries", jpegWithEXIF([]byte("II*\x00\x08\x00\x00\x00\xff\xff"))},
+		{"sub-directory past the end", corrupt(30)},
+		{"capture time past the end", corrupt(48)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := ReadMetadata(test.data)
+			if meta.Orientation < 1 || meta.Orientation > 8 || !meta.CaptureTime.IsZero() || meta.Width != 0 || meta.Height != 0 {
+				t.Fatalf("ReadMetadata = %+v", *meta)
+			}
+		})
+	}
+}
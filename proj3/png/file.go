@@ -1,12 +1,11 @@
 package png
 
 import (
+	"bytes"
 	"image"
 	"image/color"
-	"image/png"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 type Image struct {
@@ -40,7 +39,7 @@ func LoadToImage(filePath string) (image.Image, error) {
 		return nil, err
 	}
 	defer inReader.Close()
-	img, err := png.Decode(inReader)
+	img, err := Decode(inReader)
 	if err != nil {
 		return nil, err
 	}
@@ -48,12 +47,44 @@ func LoadToImage(filePath string) (image.Image, error) {
 }
 
 func Load(filePath string) (*Image, error) {
-	imgOrig, err := LoadToImage(filePath)
+	img, _, err := LoadWithMetadata(filePath)
+	return img, err
+}
+
+// LoadWithMetadata loads an image file along with its metadata, turning the image upright based on its
+// EXIF orientation. The size of the metadata falls back to the size of the loaded image
+func LoadWithMetadata(filePath string) (*Image, *Metadata, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	img := LoadFromImage(imgOrig)
-	return img, nil
+	imgOrig, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := ReadMetadata(data)
+	img := LoadFromImage(imgOrig).Orient(meta.Orientation)
+	if meta.Width == 0 || meta.Height == 0 {
+		meta.Width, meta.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	return img, meta, nil
+}
+
+// LoadSize reads the size of an image file as LoadWithMetadata would load it, turned upright based on its
+// EXIF orientation
+func LoadSize(filePath string) (int, int, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height, err := DecodeSize(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	if ReadMetadata(data).Orientation >= 5 {
+		width, height = height, width
+	}
+	return width, height, nil
 }
 
 func LoadDir(dirPath string) ([]*Image, error) {
@@ -64,8 +95,7 @@ func LoadDir(dirPath string) ([]*Image, error) {
 	imgs := []*Image{}
 	for _, file := range files {
 		filename := file.Name()
-		ext := strings.ToLower(filepath.Ext(filename))
-		if ext != ".png" {
+		if file.IsDir() {
 			continue
 		}
 		imagePath := filepath.Join(dirPath, filename)
@@ -93,6 +123,15 @@ func (img *Image) Clone(fill bool) *Image {
 }
 
 func (img *Image) Save(filePath string) error {
+	return img.SaveWith(filePath, &DefaultEncodeOptions)
+}
+
+// SaveWith saves the image with the encoder chosen by the file extension and the encoder options
+func (img *Image) SaveWith(filePath string, options *EncodeOptions) error {
+	codec, err := CodecForPath(filePath)
+	if err != nil {
+		return err
+	}
 
 	outWriter, err := os.Create(filePath)
 	if err != nil {
@@ -100,7 +139,7 @@ func (img *Image) Save(filePath string) error {
 	}
 	defer outWriter.Close()
 
-	err = png.Encode(outWriter, img)
+	err = codec.Encode(outWriter, img, options)
 	if err != nil {
 		return err
 	}
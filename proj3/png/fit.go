@@ -0,0 +1,184 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Fit resizes the image to the width and height with the resampling filter based on the fit mode: stretch
+// resizes the whole image, crop keeps the centered window with the aspect ratio of the size, letterbox fits
+// the whole image inside the size and fills the borders with the fill color, and entropy and edge keep the
+// window with the highest luminance entropy or edge density
+func (img *Image) Fit(width int, height int, mode string, filter string, fill color.Color) *Image {
+	switch mode {
+	case "crop":
+		return img.Subsize(img.CropWindow(width, height)).ResizeFilter(width, height, filter)
+	case "letterbox":
+		return img.Letterbox(width, height, filter, fill)
+	case "entropy", "edge":
+		return img.Subsize(img.SmartWindow(width, height, mode)).ResizeFilter(width, height, filter)
+	default:
+		return img.ResizeFilter(width, height, filter)
+	}
+}
+
+// cropSize computes the size of the largest window inside the bounds with the aspect ratio of the width and height
+func cropSize(bounds image.Rectangle, width int, height int) (int, int) {
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+	if cropWidth*height > cropHeight*width {
+		cropWidth = max(1, int(math.Round(float64(cropHeight*width)/float64(height))))
+	} else {
+		cropHeight = max(1, int(math.Round(float64(cropWidth*height)/float64(width))))
+	}
+	return cropWidth, cropHeight
+}
+
+// CropWindow computes the centered window with the aspect ratio of the width and height
+func (img *Image) CropWindow(width int, height int) image.Rectangle {
+	bounds := img.Bounds()
+	cropWidth, cropHeight := cropSize(bounds, width, height)
+	x0 := bounds.Min.X + (bounds.Dx()-cropWidth)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-cropHeight)/2
+	return image.Rect(x0, y0, x0+cropWidth, y0+cropHeight)
+}
+
+// SmartWindow computes the window with the aspect ratio of the width and height which has the highest
+// luminance entropy, or the highest edge density if the measure is edge. The window slides along the
+// axis the image is too long in, and ties are broken towards the center
+func (img *Image) SmartWindow(width int, height int, measure string) image.Rectangle {
+	bounds := img.Bounds()
+	cropWidth, cropHeight := cropSize(bounds, width, height)
+	alongX := bounds.Dx() > cropWidth
+	lines, span := bounds.Dy(), cropHeight
+	if alongX {
+		lines, span = bounds.Dx(), cropWidth
+	}
+	if lines <= span {
+		return img.CropWindow(width, height)
+	}
+
+	// sums the measure of each line across the sliding axis, reading two rows of luminance at a time
+	edges := make([]float64, lines)
+	var hists [][256]int
+	if measure != "edge" {
+		hists = make([][256]int, lines)
+	}
+	row := make([]float64, bounds.Dx())
+	next := make([]float64, bounds.Dx())
+	for x := range row {
+		row[x] = img.luminance(x+bounds.Min.X, bounds.Min.Y)
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		if y+1 < bounds.Dy() {
+			for x := range next {
+				next[x] = img.luminance(x+bounds.Min.X, y+1+bounds.Min.Y)
+			}
+		}
+		for x, lum := range row {
+			line := y
+			if alongX {
+				line = x
+			}
+			if hists != nil {
+				hists[line][int(lum*255+0.5)]++
+				continue
+			}
+			var dx, dy float64
+			if x+1 < len(row) {
+				dx = row[x+1] - lum
+			}
+			if y+1 < bounds.Dy() {
+				dy = next[x] - lum
+			}
+			edges[line] += math.Hypot(dx, dy)
+		}
+		row, next = next, row
+	}
+
+	// slides the window one line at a time
+	var hist [256]int
+	var edge float64
+	add := func(line int, sign int) {
+		if hists == nil {
+			edge += float64(sign) * edges[line]
+			return
+		}
+		for i, count := range hists[line] {
+			hist[i] += sign * count
+		}
+	}
+	for line := 0; line < span; line++ {
+		add(line, 1)
+	}
+	center := (lines - span) / 2
+	best, bestScore := -1, 0.0
+	for pos := 0; pos+span <= lines; pos++ {
+		if pos > 0 {
+			add(pos-1, -1)
+			add(pos+span-1, 1)
+		}
+		score := edge
+		if hists != nil {
+			score = entropy(hist[:])
+		}
+		if best < 0 || score > bestScore+1e-9 || (score > bestScore-1e-9 && abs(pos-center) < abs(best-center)) {
+			best, bestScore = pos, score
+		}
+	}
+	if alongX {
+		return image.Rect(bounds.Min.X+best, bounds.Min.Y, bounds.Min.X+best+span, bounds.Max.Y)
+	}
+	return image.Rect(bounds.Min.X, bounds.Min.Y+best, bounds.Max.X, bounds.Min.Y+best+span)
+}
+
+// entropy computes the Shannon entropy in bits of the histogram counts
+func entropy(hist []int) float64 {
+	total := 0
+	for _, count := range hist {
+		total += count
+	}
+	var sum float64
+	for _, count := range hist {
+		if count > 0 {
+			p := float64(count) / float64(total)
+			sum -= p * math.Log2(p)
+		}
+	}
+	return sum
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// Letterbox resizes the whole image with the resampling filter to fit inside the width and height keeping
+// its aspect ratio, centered on a background of the fill color
+func (img *Image) Letterbox(width int, height int, filter string, fill color.Color) *Image {
+	bounds := img.Bounds()
+	fitWidth, fitHeight := width, height
+	if bounds.Dx()*height > bounds.Dy()*width {
+		fitHeight = max(1, int(math.Round(float64(bounds.Dy()*width)/float64(bounds.Dx()))))
+	} else {
+		fitWidth = max(1, int(math.Round(float64(bounds.Dx()*height)/float64(bounds.Dy()))))
+	}
+	fitImg := img.ResizeFilter(fitWidth, fitHeight, filter)
+	x0 := (width - fitWidth) / 2
+	y0 := (height - fitHeight) / 2
+
+	newImg := NewImage(width, height)
+	fillColor := ColortoRGBA64(fill)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if x >= x0 && x < x0+fitWidth && y >= y0 && y < y0+fitHeight {
+				newImg.Set(x, y, fitImg.At(x-x0, y-y0))
+			} else {
+				newImg.Set(x, y, fillColor)
+			}
+		}
+	}
+	return newImg
+}
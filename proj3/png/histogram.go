@@ -0,0 +1,64 @@
+package png
+
+import "math"
+
+// HistogramBins is the number of bins per channel in a flattened histogram
+const HistogramBins = 256
+
+// FlattenHistogram concatenates the channels of a histogram into a single vector
+func FlattenHistogram(hist [3][256]float64) []float64 {
+	flat := make([]float64, 0, 3*HistogramBins)
+	for i := 0; i < 3; i++ {
+		flat = append(flat, hist[i][:]...)
+	}
+	return flat
+}
+
+// ChiSquare computes the chi-square distance between two flattened normalized histograms
+func ChiSquare(a []float64, b []float64) float64 {
+	var dist float64
+	for i := range a {
+		sum := a[i] + b[i]
+		if sum > 0 {
+			d := a[i] - b[i]
+			dist += d * d / sum
+		}
+	}
+	return dist / float64(len(a)/HistogramBins)
+}
+
+// Bhattacharyya computes the Bhattacharyya (Hellinger) distance between two flattened normalized histograms
+func Bhattacharyya(a []float64, b []float64) float64 {
+	var dist float64
+	for c := 0; c < len(a); c += HistogramBins {
+		var coef float64
+		for i := c; i < c+HistogramBins; i++ {
+			coef += math.Sqrt(a[i] * b[i])
+		}
+		dist += math.Sqrt(math.Max(0, 1-coef))
+	}
+	return dist / float64(len(a)/HistogramBins)
+}
+
+// Intersection computes one minus the histogram intersection of two flattened normalized histograms
+func Intersection(a []float64, b []float64) float64 {
+	var common float64
+	for i := range a {
+		common += math.Min(a[i], b[i])
+	}
+	return 1 - common/float64(len(a)/HistogramBins)
+}
+
+// EarthMover computes the 1-D earth mover's distance per channel of two flattened normalized histograms
+func EarthMover(a []float64, b []float64) float64 {
+	var dist float64
+	for c := 0; c < len(a); c += HistogramBins {
+		var carry float64
+		for i := c; i < c+HistogramBins; i++ {
+			carry += a[i] - b[i]
+			dist += math.Abs(carry)
+		}
+	}
+	channels := len(a) / HistogramBins
+	return dist / float64(channels*(HistogramBins-1))
+}
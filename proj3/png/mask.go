@@ -0,0 +1,54 @@
+package png
+
+import (
+	"image"
+	"image/color"
+)
+
+// Covered checks if the pixel is covered by the mask. A nil mask covers every pixel
+func Covered(mask *image.Alpha, x int, y int) bool {
+	return mask == nil || mask.AlphaAt(x, y).A > 0
+}
+
+// coveredIn checks if any pixel inside the bounds is covered by the mask
+func coveredIn(mask *image.Alpha, bounds image.Rectangle) bool {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if Covered(mask, x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SubsizeMask copies the pixels of the bounds which are covered by the mask, whose coordinates are
+// relative to the bounds. Pixels which are not covered are never read and are left transparent
+func (img *Image) SubsizeMask(bounds image.Rectangle, mask *image.Alpha) *Image {
+	newImg := NewImage(bounds.Dx(), bounds.Dy())
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			if !Covered(mask, x, y) {
+				continue
+			}
+			r, g, b, a := img.At(x+bounds.Min.X, y+bounds.Min.Y).RGBA()
+			newImg.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+	return newImg
+}
+
+// SubsizeExtend copies the pixels of the bounds, repeating the edge pixels of the image for the parts outside it
+func (img *Image) SubsizeExtend(bounds image.Rectangle) *Image {
+	imgBounds := img.Bounds()
+	newImg := NewImage(bounds.Dx(), bounds.Dy())
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			x0 := min(max(x+bounds.Min.X, imgBounds.Min.X), imgBounds.Max.X-1)
+			y0 := min(max(y+bounds.Min.Y, imgBounds.Min.Y), imgBounds.Max.Y-1)
+			r, g, b, a := img.At(x0, y0).RGBA()
+			newImg.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+	return newImg
+}
@@ -0,0 +1,55 @@
+package png
+
+import (
+	"image"
+	"math"
+)
+
+// StdDev computes the mean standard deviation of the RGB channels (0.0 - 1.0) inside the bounds
+func (img *Image) StdDev(bounds image.Rectangle) float64 {
+	var sum, sumSq [3]float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			values := [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+			for i := 0; i < 3; i++ {
+				sum[i] += values[i]
+				sumSq[i] += values[i] * values[i]
+			}
+		}
+	}
+	totalPixels := float64(bounds.Dx() * bounds.Dy())
+	if totalPixels == 0 {
+		return 0
+	}
+	var stdDev float64
+	for i := 0; i < 3; i++ {
+		mean := sum[i] / totalPixels
+		stdDev += math.Sqrt(math.Max(0, sumSq[i]/totalPixels-mean*mean))
+	}
+	return stdDev / 3
+}
+
+// luminance computes the relative luminance (0.0 - 1.0) of the pixel, without gamma decoding
+func (img *Image) luminance(x int, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+}
+
+// EdgeEnergy computes the mean luminance gradient magnitude inside the bounds
+func (img *Image) EdgeEnergy(bounds image.Rectangle) float64 {
+	var energy float64
+	for y := bounds.Min.Y; y < bounds.Max.Y-1; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X-1; x++ {
+			lum := img.luminance(x, y)
+			dx := img.luminance(x+1, y) - lum
+			dy := img.luminance(x, y+1) - lum
+			energy += math.Hypot(dx, dy)
+		}
+	}
+	totalPixels := float64((bounds.Dx() - 1) * (bounds.Dy() - 1))
+	if totalPixels <= 0 {
+		return 0
+	}
+	return energy / totalPixels
+}
@@ -0,0 +1,145 @@
+package png
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PyramidExtension is the extension of an output saved as a Deep Zoom tile pyramid instead of a single image
+const PyramidExtension = ".dzi"
+
+// PyramidOptions holds the settings of a Deep Zoom tile pyramid. TileSize is the side of the tiles in pixels,
+// Overlap is the number of pixels each tile shares with its neighbours, and Format is the file extension of
+// the tiles, without the dot
+type PyramidOptions struct {
+	TileSize int
+	Overlap  int
+	Format   string
+}
+
+// DefaultPyramidOptions are the pyramid settings of most Deep Zoom viewers
+var DefaultPyramidOptions = PyramidOptions{TileSize: 256, Overlap: 1, Format: "jpg"}
+
+//go:embed viewer.html
+var viewerPage string
+
+var viewerTemplate = template.Must(template.New("viewer").Parse(viewerPage))
+
+// IsPyramidPath checks if an output path is saved as a tile pyramid
+func IsPyramidPath(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == PyramidExtension
+}
+
+// pyramidLevels returns the number of levels of a Deep Zoom pyramid, whose first level is a single pixel
+// and whose last level has the full size
+func pyramidLevels(width int, height int) int {
+	return bits.Len(uint(max(width, height)-1)) + 1
+}
+
+// SavePyramid saves the image as a Deep Zoom pyramid at the path of the .dzi descriptor. The tiles of each level
+// are written to the directory named after the descriptor with a _files suffix, each level being the next one
+// downsampled by two, and a viewer page working offline is written next to the descriptor. Tiles are encoded by
+// the given number of goroutines
+func (img *Image) SavePyramid(filePath string, options *PyramidOptions, encode *EncodeOptions, threads int) error {
+	if _, err := CodecForPath("tile." + options.Format); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	tilesDir := base + "_files"
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	levels := pyramidLevels(width, height)
+
+	descriptor := fmt.Sprintf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"+
+		"<Image xmlns=\"http://schemas.microsoft.com/deepzoom/2008\" Format=\"%s\" Overlap=\"%d\" TileSize=\"%d\">\n"+
+		"  <Size Width=\"%d\" Height=\"%d\"/>\n"+
+		"</Image>\n", options.Format, options.Overlap, options.TileSize, width, height)
+	if err := os.WriteFile(filePath, []byte(descriptor), 0644); err != nil {
+		return err
+	}
+	if err := img.saveViewer(base+".html", filepath.Base(tilesDir), options, levels-1); err != nil {
+		return err
+	}
+
+	level := img
+	for i := levels - 1; i >= 0; i-- {
+		levelDir := filepath.Join(tilesDir, fmt.Sprint(i))
+		if err := os.MkdirAll(levelDir, 0755); err != nil {
+			return err
+		}
+		if err := level.saveTiles(levelDir, options, encode, threads); err != nil {
+			return err
+		}
+		if i > 0 {
+			level = level.BoxShrink(2, 2)
+		}
+	}
+	return nil
+}
+
+// saveTiles cuts a level of a pyramid into tiles named by their column and row, and saves them in the directory
+func (img *Image) saveTiles(dir string, options *PyramidOptions, encode *EncodeOptions, threads int) error {
+	bounds := img.Bounds()
+	size, overlap := options.TileSize, options.Overlap
+	cols := (bounds.Dx() + size - 1) / size
+	rows := (bounds.Dy() + size - 1) / size
+
+	tileChannel := make(chan image.Point, cols*rows)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			tileChannel <- image.Pt(col, row)
+		}
+	}
+	close(tileChannel)
+
+	var wg sync.WaitGroup
+	errs := make([]error, max(1, threads))
+	for i := range errs {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for pos := range tileChannel {
+				rect := image.Rect(pos.X*size-overlap, pos.Y*size-overlap, (pos.X+1)*size+overlap, (pos.Y+1)*size+overlap)
+				rect = rect.Add(bounds.Min).Intersect(bounds)
+				tilePath := filepath.Join(dir, fmt.Sprintf("%d_%d.%s", pos.X, pos.Y, options.Format))
+				if err := img.Subsize(rect).SaveWith(tilePath, encode); err != nil && errs[id] == nil {
+					errs[id] = err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveViewer writes the viewer page of a pyramid, which shows the tiles of the directory relative to the page
+func (img *Image) saveViewer(filePath string, tilesDir string, options *PyramidOptions, maxLevel int) error {
+	page, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer page.Close()
+	bounds := img.Bounds()
+	return viewerTemplate.Execute(page, map[string]interface{}{
+		"Title":    filepath.Base(filePath),
+		"Width":    bounds.Dx(),
+		"Height":   bounds.Dy(),
+		"TileSize": options.TileSize,
+		"Overlap":  options.Overlap,
+		"Format":   options.Format,
+		"MaxLevel": maxLevel,
+		"TilesDir": filepath.ToSlash(tilesDir),
+	})
+}
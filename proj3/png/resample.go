@@ -0,0 +1,181 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// filter represents a resampling kernel, which is zero outside of the support
+type filter struct {
+	support float64
+	kernel  func(x float64) float64
+}
+
+// filters maps the names of the resampling filters to their kernels. Nearest neighbour has no kernel
+var filters = map[string]filter{
+	"bilinear": {1, func(x float64) float64 {
+		return 1 - math.Abs(x)
+	}},
+	"bicubic": {2, catmullRom},
+	"lanczos": {3, func(x float64) float64 {
+		return sinc(x) * sinc(x/3)
+	}},
+}
+
+// catmullRom computes the Catmull-Rom cubic kernel
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return (3*x*x*x - 5*x*x + 2) / 2
+	}
+	return (-x*x*x + 5*x*x - 8*x + 4) / 2
+}
+
+// sinc computes the normalized sinc function
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contribution represents the weights of the source pixels, starting from start, of one destination pixel
+type contribution struct {
+	start   int
+	weights []float64
+}
+
+// contributions computes the weights of the source pixels for every destination pixel along one axis.
+// The kernel is widened by the downscale ratio so that every source pixel contributes
+func contributions(srcSize int, dstSize int, f filter) []contribution {
+	scale := float64(srcSize) / float64(dstSize)
+	stretch := math.Max(scale, 1)
+	support := f.support * stretch
+	contribs := make([]contribution, dstSize)
+	for i := range contribs {
+		center := (float64(i) + 0.5) * scale
+		start := max(int(math.Floor(center-support)), 0)
+		end := min(int(math.Ceil(center+support)), srcSize)
+		weights := make([]float64, 0, end-start)
+		var sum float64
+		for j := start; j < end; j++ {
+			weight := 0.0
+			if x := (float64(j) + 0.5 - center) / stretch; math.Abs(x) < f.support {
+				weight = f.kernel(x)
+			}
+			weights = append(weights, weight)
+			sum += weight
+		}
+		if sum == 0 {
+			// falls back to the nearest source pixel
+			start = min(int(center), srcSize-1)
+			weights = []float64{1}
+			sum = 1
+		}
+		for j := range weights {
+			weights[j] /= sum
+		}
+		contribs[i] = contribution{start, weights}
+	}
+	return contribs
+}
+
+// ResizeFilter resizes the image with the resampling filter: nearest, bilinear, bicubic (Catmull-Rom) or
+// lanczos (Lanczos-3). The kernel is applied in two separable passes, after a box filter shrinks the image
+// to about twice the size for large downscale ratios
+func (img *Image) ResizeFilter(width int, height int, name string) *Image {
+	return img.ResizeFilterRows(width, height, name, 0, height)
+}
+
+// ResizeFilterRows computes the rows from top to bottom of the image resized by ResizeFilter, keeping their position
+// in the bounds. Only the source rows which contribute to them are resampled
+func (img *Image) ResizeFilterRows(width int, height int, name string, top int, bottom int) *Image {
+	f, found := filters[name]
+	if !found {
+		return img.ResizeRows(width, height, top, bottom)
+	}
+	src := img
+	factorX := src.Bounds().Dx() / (2 * width)
+	factorY := src.Bounds().Dy() / (2 * height)
+	if factorX > 1 || factorY > 1 {
+		src = src.BoxShrink(max(factorX, 1), max(factorY, 1))
+	}
+	bounds := src.Bounds()
+
+	// resamples the source rows used by the destination rows into premultiplied channels
+	rowContribs := contributions(bounds.Dy(), height, f)[top:bottom]
+	srcTop, srcBottom := bounds.Dy(), 0
+	for _, contrib := range rowContribs {
+		srcTop = min(srcTop, contrib.start)
+		srcBottom = max(srcBottom, contrib.start+len(contrib.weights))
+	}
+	colContribs := contributions(bounds.Dx(), width, f)
+	rows := make([][4]float64, width*max(srcBottom-srcTop, 0))
+	for y := srcTop; y < srcBottom; y++ {
+		for x, contrib := range colContribs {
+			var sum [4]float64
+			for i, weight := range contrib.weights {
+				c := src.RGBA64At(bounds.Min.X+contrib.start+i, bounds.Min.Y+y)
+				sum[0] += weight * float64(c.R)
+				sum[1] += weight * float64(c.G)
+				sum[2] += weight * float64(c.B)
+				sum[3] += weight * float64(c.A)
+			}
+			rows[(y-srcTop)*width+x] = sum
+		}
+	}
+
+	// resamples the columns, clamping the overshoot of negative kernel lobes
+	newImg := &Image{image.NewRGBA64(image.Rect(0, top, width, bottom))}
+	for row, contrib := range rowContribs {
+		y := top + row
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for i, weight := range contrib.weights {
+				value := rows[(contrib.start+i-srcTop)*width+x]
+				for c := 0; c < 4; c++ {
+					sum[c] += weight * value[c]
+				}
+			}
+			a := math.Round(math.Min(math.Max(sum[3], 0), 0xffff))
+			channel := func(value float64) uint16 {
+				return uint16(math.Round(math.Min(math.Max(value, 0), a)))
+			}
+			newImg.SetRGBA64(x, y, color.RGBA64{channel(sum[0]), channel(sum[1]), channel(sum[2]), uint16(a)})
+		}
+	}
+	return newImg
+}
+
+// BoxShrink averages blocks of factorX x factorY pixels, where the blocks at the right and bottom edges may be smaller
+func (img *Image) BoxShrink(factorX int, factorY int) *Image {
+	bounds := img.Bounds()
+	width := (bounds.Dx() + factorX - 1) / factorX
+	height := (bounds.Dy() + factorY - 1) / factorY
+	newImg := NewImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			block := image.Rect(x*factorX, y*factorY, (x+1)*factorX, (y+1)*factorY).Add(bounds.Min).Intersect(bounds)
+			var sum [4]float64
+			for y0 := block.Min.Y; y0 < block.Max.Y; y0++ {
+				for x0 := block.Min.X; x0 < block.Max.X; x0++ {
+					c := img.RGBA64At(x0, y0)
+					sum[0] += float64(c.R)
+					sum[1] += float64(c.G)
+					sum[2] += float64(c.B)
+					sum[3] += float64(c.A)
+				}
+			}
+			totalPixels := float64(block.Dx() * block.Dy())
+			newImg.SetRGBA64(x, y, color.RGBA64{
+				uint16(math.Round(sum[0] / totalPixels)),
+				uint16(math.Round(sum[1] / totalPixels)),
+				uint16(math.Round(sum[2] / totalPixels)),
+				uint16(math.Round(sum[3] / totalPixels)),
+			})
+		}
+	}
+	return newImg
+}
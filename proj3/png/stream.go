@@ -0,0 +1,189 @@
+package png
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// zlibLevels maps the PNG compression levels to the zlib levels used by the stream encoder
+var zlibLevels = map[png.CompressionLevel]int{
+	png.DefaultCompression: zlib.DefaultCompression,
+	png.NoCompression:      zlib.NoCompression,
+	png.BestSpeed:          zlib.BestSpeed,
+	png.BestCompression:    zlib.BestCompression,
+}
+
+// StreamEncoder writes a PNG image row by row, so that the image does not have to be held in memory as a whole.
+// Rows are written as 16 bit RGBA, with the row filter chosen like the standard encoder does
+type StreamEncoder struct {
+	w        io.Writer
+	width    int
+	height   int
+	written  int
+	filter   bool
+	idat     *bufio.Writer
+	zw       *zlib.Writer
+	prev     []byte
+	cur      []byte
+	filtered [5][]byte
+}
+
+// chunkWriter writes the data written to it as chunks of a type
+type chunkWriter struct {
+	w    io.Writer
+	kind string
+}
+
+func (c *chunkWriter) Write(data []byte) (int, error) {
+	if err := writeChunk(c.w, c.kind, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writeChunk writes a PNG chunk with its length and checksum
+func writeChunk(w io.Writer, kind string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], kind)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+	for _, part := range [][]byte{header, data, footer} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewStreamEncoder writes the header of a PNG image of the size, compressed with the PNG compression of the options
+func NewStreamEncoder(w io.Writer, width int, height int, options *EncodeOptions) (*StreamEncoder, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("png: invalid image size")
+	}
+	level := PNGCompressions[options.PNGCompression]
+	enc := &StreamEncoder{w: w, width: width, height: height, filter: level != png.NoCompression}
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header, uint32(width))
+	binary.BigEndian.PutUint32(header[4:], uint32(height))
+	// 16 bits per sample, truecolor with alpha, no interlace
+	header[8], header[9] = 16, 6
+	if err := writeChunk(w, "IHDR", header); err != nil {
+		return nil, err
+	}
+
+	enc.idat = bufio.NewWriterSize(&chunkWriter{w, "IDAT"}, 1<<16)
+	zw, err := zlib.NewWriterLevel(enc.idat, zlibLevels[level])
+	if err != nil {
+		return nil, err
+	}
+	enc.zw = zw
+	rowBytes := 1 + 8*width
+	enc.prev = make([]byte, rowBytes)
+	enc.cur = make([]byte, rowBytes)
+	for i := range enc.filtered {
+		enc.filtered[i] = make([]byte, rowBytes)
+	}
+	return enc, nil
+}
+
+// WriteRows writes the rows from top to bottom of the image, which must follow the rows written before
+func (enc *StreamEncoder) WriteRows(img *Image, top int, bottom int) error {
+	if top != enc.written || bottom > enc.height {
+		return errors.New("png: rows written out of order")
+	}
+	bounds := img.Bounds()
+	for y := top; y < bottom; y++ {
+		for x := 0; x < enc.width; x++ {
+			c := color.NRGBA64Model.Convert(img.RGBA64At(bounds.Min.X+x, y)).(color.NRGBA64)
+			pixel := enc.cur[1+8*x:]
+			binary.BigEndian.PutUint16(pixel, c.R)
+			binary.BigEndian.PutUint16(pixel[2:], c.G)
+			binary.BigEndian.PutUint16(pixel[4:], c.B)
+			binary.BigEndian.PutUint16(pixel[6:], c.A)
+		}
+		row := enc.cur
+		if enc.filter {
+			row = enc.filterRow()
+		}
+		if _, err := enc.zw.Write(row); err != nil {
+			return err
+		}
+		enc.prev, enc.cur = enc.cur, enc.prev
+		enc.written++
+	}
+	return nil
+}
+
+// filterRow applies every row filter to the current row and returns the one with the smallest sum of
+// absolute differences
+func (enc *StreamEncoder) filterRow() []byte {
+	const bpp = 8
+	cur, prev := enc.cur[1:], enc.prev[1:]
+	best, bestSum := 0, -1
+	for kind := range enc.filtered {
+		out := enc.filtered[kind]
+		out[0] = byte(kind)
+		sum := 0
+		for i, value := range cur {
+			var left, up, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up = prev[i]
+			switch kind {
+			case 1:
+				value -= left
+			case 2:
+				value -= up
+			case 3:
+				value -= byte((int(left) + int(up)) / 2)
+			case 4:
+				value -= paeth(left, up, upLeft)
+			}
+			out[1+i] = value
+			sum += abs(int(int8(value)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = kind, sum
+		}
+	}
+	return enc.filtered[best]
+}
+
+// paeth returns the neighbour closest to the Paeth prediction
+func paeth(a byte, b byte, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// Close finishes the image once all rows are written
+func (enc *StreamEncoder) Close() error {
+	if enc.written != enc.height {
+		return errors.New("png: image closed before all rows were written")
+	}
+	if err := enc.zw.Close(); err != nil {
+		return err
+	}
+	if err := enc.idat.Flush(); err != nil {
+		return err
+	}
+	return writeChunk(enc.w, "IEND", nil)
+}
@@ -0,0 +1,457 @@
+package png
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// TIFF tags read or written by the codec
+const (
+	tagWidth           = 256
+	tagHeight          = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagPlanarConfig    = 284
+	tagResolutionUnit  = 296
+	tagPredictor       = 317
+	tagColorMap        = 320
+	tagTileWidth       = 322
+	tagExtraSamples    = 338
+)
+
+// TIFF compression schemes
+const (
+	tiffNone     = 1
+	tiffLZW      = 5
+	tiffPackBits = 32773
+)
+
+// TIFF field types
+const (
+	tiffByte     = 1
+	tiffASCII    = 2
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+// TIFFCompressions maps the names of the TIFF compression schemes to their tag values
+var TIFFCompressions = map[string]int{
+	"none":     tiffNone,
+	"packbits": tiffPackBits,
+	"lzw":      tiffLZW,
+}
+
+// tiffMaxSamples bounds the number of samples of a TIFF image, so that a malformed header cannot make the
+// decoder allocate a huge image
+const tiffMaxSamples = 1 << 28
+
+var errTIFFFormat = errors.New("png: invalid TIFF file")
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:       "tiff",
+		Magic:      []string{"II*\x00", "MM\x00*"},
+		Extensions: []string{".tif", ".tiff"},
+		Decode:     DecodeTIFF,
+		Encode:     EncodeTIFF,
+	})
+}
+
+// tiffFieldSize returns the size of a value of a TIFF field type, or 0 if the type is not read
+func tiffFieldSize(kind uint16) int {
+	switch kind {
+	case tiffByte, tiffASCII:
+		return 1
+	case tiffShort:
+		return 2
+	case tiffLong:
+		return 4
+	}
+	return 0
+}
+
+// readIFD reads the integer and text fields of the image file directory at the offset. Text fields hold
+// one value per character
+func readIFD(data []byte, order binary.ByteOrder, offset int) (map[uint16][]uint32, error) {
+	if offset < 8 || offset+2 > len(data) {
+		return nil, errTIFFFormat
+	}
+	count := int(order.Uint16(data[offset:]))
+	if offset+2+12*count > len(data) {
+		return nil, errTIFFFormat
+	}
+	fields := map[uint16][]uint32{}
+	for i := 0; i < count; i++ {
+		entry := data[offset+2+12*i:]
+		tag, kind, n := order.Uint16(entry), order.Uint16(entry[2:]), int(order.Uint32(entry[4:]))
+		size := tiffFieldSize(kind)
+		if size == 0 {
+			continue
+		}
+		values := entry[8:12]
+		if size*n > 4 {
+			at := int(order.Uint32(entry[8:]))
+			if n > len(data) || at < 0 || at+size*n > len(data) {
+				return nil, errTIFFFormat
+			}
+			values = data[at : at+size*n]
+		}
+		field := make([]uint32, n)
+		for j := range field {
+			switch size {
+			case 1:
+				field[j] = uint32(values[j])
+			case 2:
+				field[j] = uint32(order.Uint16(values[2*j:]))
+			case 4:
+				field[j] = order.Uint32(values[4*j:])
+			}
+		}
+		fields[tag] = field
+	}
+	return fields, nil
+}
+
+// DecodeTIFF reads the first image of a baseline TIFF file. Gray, RGB and palette images with 8 or 16 bits per
+// sample are supported, uncompressed or compressed with PackBits or LZW. 16 bit samples are kept at full precision
+func DecodeTIFF(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errTIFFFormat
+	}
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errTIFFFormat
+	}
+	fields, err := readIFD(data, order, int(order.Uint32(data[4:])))
+	if err != nil {
+		return nil, err
+	}
+	first := func(tag uint16, def int) int {
+		if len(fields[tag]) == 0 {
+			return def
+		}
+		return int(fields[tag][0])
+	}
+
+	width, height := first(tagWidth, 0), first(tagHeight, 0)
+	if width <= 0 || height <= 0 || width > tiffMaxSamples || height > tiffMaxSamples || width*height > tiffMaxSamples {
+		return nil, errTIFFFormat
+	}
+	if len(fields[tagTileWidth]) > 0 || first(tagPlanarConfig, 1) != 1 {
+		return nil, errors.New("png: unsupported TIFF layout")
+	}
+	samples := first(tagSamplesPerPixel, 1)
+	bits := first(tagBitsPerSample, 1)
+	for _, b := range fields[tagBitsPerSample] {
+		if int(b) != bits {
+			return nil, errors.New("png: unsupported TIFF bit depth")
+		}
+	}
+	if bits != 8 && bits != 16 {
+		return nil, errors.New("png: unsupported TIFF bit depth")
+	}
+	photometric := first(tagPhotometric, -1)
+	colorSamples := 1
+	if photometric == 2 {
+		colorSamples = 3
+	} else if photometric < 0 || photometric > 3 {
+		return nil, errors.New("png: unsupported TIFF photometric interpretation")
+	}
+	// the color samples may be followed by one extra sample, which is alpha or ignored
+	if samples < colorSamples || samples > colorSamples+1 || width*height*samples > tiffMaxSamples {
+		return nil, errTIFFFormat
+	}
+	// the first extra sample is alpha if it is marked as associated (1) or unassociated (2)
+	alpha := 0
+	if samples > colorSamples && len(fields[tagExtraSamples]) > 0 {
+		alpha = int(fields[tagExtraSamples][0])
+	}
+	if photometric == 3 {
+		alpha = 0
+	}
+	colorMap := fields[tagColorMap]
+	if photometric == 3 && len(colorMap) < 3<<uint(bits) {
+		return nil, errTIFFFormat
+	}
+
+	// decompresses the strips into rows of samples
+	compression := first(tagCompression, tiffNone)
+	rowBytes := width * samples * bits / 8
+	rowsPerStrip := first(tagRowsPerStrip, height)
+	if rowsPerStrip <= 0 || rowsPerStrip > height {
+		rowsPerStrip = height
+	}
+	offsets, counts := fields[tagStripOffsets], fields[tagStripByteCounts]
+	if len(offsets) == 0 || len(counts) < len(offsets) {
+		return nil, errTIFFFormat
+	}
+	pixels := make([]byte, rowBytes*height)
+	for i, offset := range offsets {
+		start, end := int(offset), int(offset)+int(counts[i])
+		if start < 0 || end > len(data) || start > end {
+			return nil, errTIFFFormat
+		}
+		strip := data[start:end]
+		switch compression {
+		case tiffNone:
+		case tiffPackBits:
+			strip, err = unpackBits(strip)
+		case tiffLZW:
+			strip, err = unLZW(strip)
+		default:
+			return nil, errors.New("png: unsupported TIFF compression")
+		}
+		if err != nil {
+			return nil, err
+		}
+		at := i * rowsPerStrip * rowBytes
+		if at >= len(pixels) {
+			break
+		}
+		copy(pixels[at:min(len(pixels), at+rowsPerStrip*rowBytes)], strip)
+	}
+	if first(tagPredictor, 1) == 2 {
+		undoPredictor(pixels, rowBytes, samples, bits, order)
+	}
+
+	sample := func(i int) uint16 {
+		if bits == 16 {
+			return order.Uint16(pixels[2*i:])
+		}
+		return uint16(pixels[i]) * 0x101
+	}
+	var img image.Image
+	var set func(x, y int, c [4]uint16)
+	if alpha == 1 {
+		rgba := image.NewRGBA64(image.Rect(0, 0, width, height))
+		img, set = rgba, func(x, y int, c [4]uint16) { rgba.SetRGBA64(x, y, color.RGBA64{c[0], c[1], c[2], c[3]}) }
+	} else {
+		nrgba := image.NewNRGBA64(image.Rect(0, 0, width, height))
+		img, set = nrgba, func(x, y int, c [4]uint16) { nrgba.SetNRGBA64(x, y, color.NRGBA64{c[0], c[1], c[2], c[3]}) }
+	}
+	entries := 1 << uint(bits)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * samples
+			c := [4]uint16{0, 0, 0, 0xffff}
+			switch photometric {
+			case 0:
+				v := 0xffff - sample(i)
+				c[0], c[1], c[2] = v, v, v
+			case 1:
+				v := sample(i)
+				c[0], c[1], c[2] = v, v, v
+			case 2:
+				c[0], c[1], c[2] = sample(i), sample(i+1), sample(i+2)
+			case 3:
+				index := int(sample(i)) >> uint(16-bits)
+				c[0], c[1], c[2] = uint16(colorMap[index]), uint16(colorMap[entries+index]), uint16(colorMap[2*entries+index])
+			}
+			if alpha == 1 || alpha == 2 {
+				c[3] = sample(i + colorSamples)
+			}
+			set(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// undoPredictor adds the horizontal differences of the samples of each row back up
+func undoPredictor(pixels []byte, rowBytes int, samples int, bits int, order binary.ByteOrder) {
+	for row := 0; row+rowBytes <= len(pixels); row += rowBytes {
+		line := pixels[row : row+rowBytes]
+		if bits == 8 {
+			for i := samples; i < len(line); i++ {
+				line[i] += line[i-samples]
+			}
+			continue
+		}
+		for i := 2 * samples; i+1 < len(line); i += 2 {
+			order.PutUint16(line[i:], order.Uint16(line[i:])+order.Uint16(line[i-2*samples:]))
+		}
+	}
+}
+
+// applyPredictor replaces the samples of each row by their horizontal differences
+func applyPredictor(pixels []byte, rowBytes int, samples int, bits int, order binary.ByteOrder) {
+	for row := 0; row+rowBytes <= len(pixels); row += rowBytes {
+		line := pixels[row : row+rowBytes]
+		if bits == 8 {
+			for i := len(line) - 1; i >= samples; i-- {
+				line[i] -= line[i-samples]
+			}
+			continue
+		}
+		for i := len(line) - 2; i >= 2*samples; i -= 2 {
+			order.PutUint16(line[i:], order.Uint16(line[i:])-order.Uint16(line[i-2*samples:]))
+		}
+	}
+}
+
+// tiffField is an entry of the image file directory written by EncodeTIFF
+type tiffField struct {
+	tag    uint16
+	kind   uint16
+	values []uint32
+}
+
+// EncodeTIFF writes an image as an RGB TIFF with the compression of the options. Samples are written with
+// 16 bits if the image does not fit in 8 bits, and alpha is written as associated alpha if the image is
+// not opaque, so that the image is stored without loss
+func EncodeTIFF(w io.Writer, img image.Image, options *EncodeOptions) error {
+	compression, ok := TIFFCompressions[options.TIFFCompression]
+	if !ok {
+		compression = tiffNone
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	samples := 3
+	if !isOpaque(img) {
+		samples = 4
+	}
+	bits := 8
+	for y := bounds.Min.Y; y < bounds.Max.Y && bits == 8; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if r%0x101 != 0 || g%0x101 != 0 || b%0x101 != 0 || a%0x101 != 0 {
+				bits = 16
+				break
+			}
+		}
+	}
+
+	// splits the rows into strips of about 8 KB
+	order := binary.LittleEndian
+	rowBytes := width * samples * bits / 8
+	rowsPerStrip := max(1, min(height, 8192/max(1, rowBytes)))
+	strips := [][]byte{}
+	for top := 0; top < height; top += rowsPerStrip {
+		rows := min(rowsPerStrip, height-top)
+		pixels := make([]byte, 0, rows*rowBytes)
+		for y := bounds.Min.Y + top; y < bounds.Min.Y+top+rows; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := img.At(x, y).RGBA()
+				for _, v := range []uint32{r, g, b, a}[:samples] {
+					if bits == 8 {
+						pixels = append(pixels, uint8(v>>8))
+					} else {
+						pixels = order.AppendUint16(pixels, uint16(v))
+					}
+				}
+			}
+		}
+		switch compression {
+		case tiffPackBits:
+			packed := []byte{}
+			for row := 0; row < len(pixels); row += rowBytes {
+				packed = packBits(packed, pixels[row:row+rowBytes])
+			}
+			pixels = packed
+		case tiffLZW:
+			applyPredictor(pixels, rowBytes, samples, bits, order)
+			pixels = lzw(pixels)
+		}
+		strips = append(strips, pixels)
+	}
+
+	// the strips follow the header, then the directory and the values which do not fit in its entries
+	offset := 8
+	stripOffsets := make([]uint32, len(strips))
+	stripCounts := make([]uint32, len(strips))
+	for i, strip := range strips {
+		stripOffsets[i] = uint32(offset)
+		stripCounts[i] = uint32(len(strip))
+		offset += len(strip)
+	}
+	padding := offset % 2
+	offset += padding
+	bitsPerSample := make([]uint32, samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = uint32(bits)
+	}
+	fields := []tiffField{
+		{tagWidth, tiffLong, []uint32{uint32(width)}},
+		{tagHeight, tiffLong, []uint32{uint32(height)}},
+		{tagBitsPerSample, tiffShort, bitsPerSample},
+		{tagCompression, tiffShort, []uint32{uint32(compression)}},
+		{tagPhotometric, tiffShort, []uint32{2}},
+		{tagStripOffsets, tiffLong, stripOffsets},
+		{tagSamplesPerPixel, tiffShort, []uint32{uint32(samples)}},
+		{tagRowsPerStrip, tiffLong, []uint32{uint32(rowsPerStrip)}},
+		{tagStripByteCounts, tiffLong, stripCounts},
+		{tagXResolution, tiffRational, []uint32{72, 1}},
+		{tagYResolution, tiffRational, []uint32{72, 1}},
+		{tagResolutionUnit, tiffShort, []uint32{2}},
+	}
+	if compression == tiffLZW {
+		fields = append(fields, tiffField{tagPredictor, tiffShort, []uint32{2}})
+	}
+	if samples == 4 {
+		fields = append(fields, tiffField{tagExtraSamples, tiffShort, []uint32{1}})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].tag < fields[j].tag
+	})
+
+	directory := make([]byte, 2+12*len(fields)+4)
+	extra := []byte{}
+	extraOffset := offset + len(directory)
+	order.PutUint16(directory, uint16(len(fields)))
+	for i, field := range fields {
+		entry := directory[2+12*i:]
+		count := len(field.values)
+		values := []byte{}
+		for _, v := range field.values {
+			if field.kind == tiffShort {
+				values = order.AppendUint16(values, uint16(v))
+			} else {
+				values = order.AppendUint32(values, v)
+			}
+		}
+		if field.kind == tiffRational {
+			count /= 2
+		}
+		order.PutUint16(entry, field.tag)
+		order.PutUint16(entry[2:], field.kind)
+		order.PutUint32(entry[4:], uint32(count))
+		if len(values) <= 4 {
+			copy(entry[8:12], values)
+		} else {
+			order.PutUint32(entry[8:], uint32(extraOffset+len(extra)))
+			extra = append(extra, values...)
+		}
+	}
+
+	header := make([]byte, 8)
+	copy(header, "II*\x00")
+	order.PutUint32(header[4:], uint32(offset))
+	chunks := append([][]byte{header}, strips...)
+	chunks = append(chunks, make([]byte, padding), directory, extra)
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"sort"
+	"testing"
+)
+
+// tiffFile assembles an uncompressed TIFF file with one strip of pixels. Fields are short unless a value
+// needs a long, and their values follow the directory if they do not fit in their entries
+func tiffFile(order binary.AppendByteOrder, fields map[uint16][]uint32, pixels []byte) []byte {
+	tags := []uint16{}
+	for tag := range fields {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i] < tags[j]
+	})
+	magic := "II*\x00"
+	if order == binary.BigEndian {
+		magic = "MM\x00*"
+	}
+	// the directory starts on a word boundary after the pixels
+	directoryOffset := 8 + len(pixels) + len(pixels)%2
+	data := order.AppendUint32([]byte(magic), uint32(directoryOffset))
+	data = append(data, pixels...)
+	data = append(data, make([]byte, directoryOffset-len(data))...)
+	extraOffset := len(data) + 2 + 12*(len(tags)+2) + 4
+	extra := []byte{}
+	data = order.AppendUint16(data, uint16(len(tags)+2))
+	entry := func(tag uint16, kind uint16, values []uint32) {
+		encoded := []byte{}
+		for _, v := range values {
+			if kind == tiffShort {
+				encoded = order.AppendUint16(encoded, uint16(v))
+			} else {
+				encoded = order.AppendUint32(encoded, v)
+			}
+		}
+		data = order.AppendUint16(data, tag)
+		data = order.AppendUint16(data, kind)
+		data = order.AppendUint32(data, uint32(len(values)))
+		if len(encoded) <= 4 {
+			data = append(data, append(encoded, make([]byte, 4-len(encoded))...)...)
+		} else {
+			data = order.AppendUint32(data, uint32(extraOffset+len(extra)))
+			extra = append(extra, encoded...)
+		}
+	}
+	for _, tag := range tags {
+		kind := uint16(tiffShort)
+		for _, v := range fields[tag] {
+			if v > 0xffff {
+				kind = tiffLong
+			}
+		}
+		entry(tag, kind, fields[tag])
+	}
+	// the strip is the only one, placed right after the header
+	entry(tagStripOffsets, tiffLong, []uint32{8})
+	entry(tagStripByteCounts, tiffLong, []uint32{uint32(len(pixels))})
+	data = append(data, 0, 0, 0, 0)
+	return append(data, extra...)
+}
+
+func TestDecodeTIFF(t *testing.T) {
+	gray := func(v uint8) color.NRGBA64 {
+		return color.NRGBA64{uint16(v) * 0x101, uint16(v) * 0x101, uint16(v) * 0x101, 0xffff}
+	}
+	// the color map holds the red, then the green, then the blue values of the entries, of which the first
+	// three are red, green and blue
+	colorMap := make([]uint32, 3*256)
+	colorMap[0], colorMap[256+1], colorMap[512+2] = 0xffff, 0xffff, 0xffff
+	tests := []struct {
+		name   string
+		data   []byte
+		pixels []color.NRGBA64
+	}{
+		{
+			"gray big endian",
+			tiffFile(binary.BigEndian, map[uint16][]uint32{
+				tagWidth: {3}, tagHeight: {1}, tagBitsPerSample: {8}, tagPhotometric: {1},
+			}, []byte{0, 0x80, 0xff}),
+			[]color.NRGBA64{gray(0), gray(0x80), gray(0xff)},
+		},
+		{
+			"gray white is zero",
+			tiffFile(binary.LittleEndian, map[uint16][]uint32{
+				tagWidth: {3}, tagHeight: {1}, tagBitsPerSample: {8}, tagPhotometric: {0},
+			}, []byte{0, 0x80, 0xff}),
+			[]color.NRGBA64{gray(0xff), gray(0x7f), gray(0)},
+		},
+		{
+			"gray 16 bit big endian",
+			tiffFile(binary.BigEndian, map[uint16][]uint32{
+				tagWidth: {2}, tagHeight: {1}, tagBitsPerSample: {16}, tagPhotometric: {1},
+			}, []byte{0x12, 0x34, 0xab, 0xcd}),
+			[]color.NRGBA64{{0x1234, 0x1234, 0x1234, 0xffff}, {0xabcd, 0xabcd, 0xabcd, 0xffff}},
+		},
+		{
+			"palette",
+			tiffFile(binary.LittleEndian, map[uint16][]uint32{
+				tagWidth: {3}, tagHeight: {1}, tagBitsPerSample: {8}, tagPhotometric: {3}, tagColorMap: colorMap,
+			}, []byte{0, 1, 2}),
+			[]color.NRGBA64{{0xffff, 0, 0, 0xffff}, {0, 0xffff, 0, 0xffff}, {0, 0, 0xffff, 0xffff}},
+		},
+		{
+			"unassociated alpha",
+			tiffFile(binary.LittleEndian, map[uint16][]uint32{
+				tagWidth: {1}, tagHeight: {1}, tagBitsPerSample: {8, 8, 8, 8}, tagPhotometric: {2},
+				tagSamplesPerPixel: {4}, tagExtraSamples: {2},
+			}, []byte{0x10, 0x20, 0x30, 0x80}),
+			[]color.NRGBA64{{0x1010, 0x2020, 0x3030, 0x8080}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := DecodeTIFF(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size.X != len(test.pixels) || size.Y != 1 {
+				t.Fatalf("size %v, want %dx1", size, len(test.pixels))
+			}
+			for x, want := range test.pixels {
+				if got := color.NRGBA64Model.Convert(img.At(x, 0)); got != want {
+					t.Errorf("pixel %d is %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTIFFInvalid(t *testing.T) {
+	rgb := map[uint16][]uint32{tagWidth: {1}, tagHeight: {1}, tagBitsPerSample: {8, 8, 8}, tagPhotometric: {2}, tagSamplesPerPixel: {3}}
+	with := func(tag uint16, values ...uint32) []byte {
+		fields := map[uint16][]uint32{}
+		for k, v := range rgb {
+			fields[k] = v
+		}
+		fields[tag] = values
+		return tiffFile(binary.LittleEndian, fields, []byte{1, 2, 3})
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", []byte("II+\x00\x08\x00\x00\x00")},
+		{"truncated", with(tagCompression, tiffNone)[:20]},
+		{"zero width", with(tagWidth, 0)},
+		{"unsupported compression", with(tagCompression, 7)},
+		{"unsupported bit depth", with(tagBitsPerSample, 4, 4, 4)},
+		{"unsupported photometric", with(tagPhotometric, 5)},
+		{"planar", with(tagPlanarConfig, 2)},
+		{"too many samples", with(tagSamplesPerPixel, 60000)},
+		{"two extra samples", with(tagSamplesPerPixel, 5)},
+		{"too many pixels", with(tagHeight, 1<<29)},
+		{"overflowing size", tiffFile(binary.LittleEndian, map[uint16][]uint32{
+			tagWidth: {0xffffffff}, tagHeight: {0xffffffff}, tagBitsPerSample: {8}, tagPhotometric: {1},
+		}, []byte{1})},
+		{"too many samples for the pixels", tiffFile(binary.LittleEndian, map[uint16][]uint32{
+			tagWidth: {1 << 14}, tagHeight: {1 << 14}, tagBitsPerSample: {8, 8, 8, 8}, tagPhotometric: {2},
+			tagSamplesPerPixel: {4}, tagExtraSamples: {2},
+		}, []byte{1, 2, 3, 4})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeTIFF(bytes.NewReader(test.data)); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
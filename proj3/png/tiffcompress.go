@@ -0,0 +1,184 @@
+package png
+
+import "errors"
+
+// LZW codes of TIFF, whose code width grows one code earlier than in GIF
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxWidth = 12
+	lzwMaxCode  = 1<<lzwMaxWidth - 2
+)
+
+var errLZW = errors.New("png: invalid LZW data")
+
+// unpackBits expands PackBits data
+func unpackBits(src []byte) ([]byte, error) {
+	dst := []byte{}
+	for i := 0; i < len(src); {
+		n := int(int8(src[i]))
+		i++
+		if n >= 0 {
+			if i+n+1 > len(src) {
+				return nil, errors.New("png: invalid PackBits data")
+			}
+			dst = append(dst, src[i:i+n+1]...)
+			i += n + 1
+		} else if n != -128 {
+			if i >= len(src) {
+				return nil, errors.New("png: invalid PackBits data")
+			}
+			for j := 0; j < 1-n; j++ {
+				dst = append(dst, src[i])
+			}
+			i++
+		}
+	}
+	return dst, nil
+}
+
+// packBits appends the PackBits encoding of src to dst. Runs of three or more equal bytes are replicated,
+// others are copied as literals
+func packBits(dst []byte, src []byte) []byte {
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && run < 128 && src[i+run] == src[i] {
+			run++
+		}
+		if run >= 2 {
+			dst = append(dst, byte(1-run), src[i])
+			i += run
+			continue
+		}
+		start := i
+		for i < len(src) && i-start < 128 {
+			if i+2 < len(src) && src[i] == src[i+1] && src[i] == src[i+2] {
+				break
+			}
+			i++
+		}
+		dst = append(dst, byte(i-start-1))
+		dst = append(dst, src[start:i]...)
+	}
+	return dst
+}
+
+// unLZW expands TIFF LZW data, whose codes are packed starting from the most significant bit
+func unLZW(src []byte) ([]byte, error) {
+	dst := []byte{}
+	table := make([][]byte, 1<<lzwMaxWidth)
+	width, next := 9, lzwFirst
+	var prev []byte
+	var bits uint32
+	var count int
+	for i := 0; ; {
+		for count < width && i < len(src) {
+			bits = bits<<8 | uint32(src[i])
+			count += 8
+			i++
+		}
+		if count < width {
+			// data ending without an end of information code
+			return dst, nil
+		}
+		code := int(bits>>uint(count-width)) & (1<<width - 1)
+		count -= width
+
+		if code == lzwClear {
+			width, next, prev = 9, lzwFirst, nil
+			continue
+		}
+		if code == lzwEOI {
+			return dst, nil
+		}
+		var entry []byte
+		if code < lzwClear {
+			entry = []byte{byte(code)}
+		} else if code < next {
+			entry = table[code]
+		} else if code == next && prev != nil {
+			entry = append(append([]byte{}, prev...), prev[0])
+		} else {
+			return nil, errLZW
+		}
+		dst = append(dst, entry...)
+		if prev != nil && next < len(table) {
+			table[next] = append(append([]byte{}, prev...), entry[0])
+			next++
+		}
+		if next >= 1<<width-1 && width < lzwMaxWidth {
+			width++
+		}
+		prev = entry
+	}
+}
+
+// lzwWriter packs codes into bytes starting from the most significant bit
+type lzwWriter struct {
+	dst   []byte
+	bits  uint32
+	count int
+}
+
+func (w *lzwWriter) write(code int, width int) {
+	w.bits = w.bits<<uint(width) | uint32(code)
+	w.count += width
+	for w.count >= 8 {
+		w.dst = append(w.dst, byte(w.bits>>uint(w.count-8)))
+		w.count -= 8
+	}
+}
+
+func (w *lzwWriter) flush() []byte {
+	if w.count > 0 {
+		w.dst = append(w.dst, byte(w.bits<<uint(8-w.count)))
+		w.count = 0
+	}
+	return w.dst
+}
+
+// lzw encodes data with TIFF LZW, clearing the table once it is full
+func lzw(src []byte) []byte {
+	w := &lzwWriter{}
+	width, next := 9, lzwFirst
+	table := map[int]int{}
+	w.write(lzwClear, width)
+	// add counts a code, as the decoder adds a table entry for every code after the first one
+	add := func() {
+		if next == 1<<width-1 && width < lzwMaxWidth {
+			width++
+		}
+		next++
+	}
+	prefix := -1
+	for _, b := range src {
+		if prefix < 0 {
+			prefix = int(b)
+			continue
+		}
+		key := prefix<<8 | int(b)
+		if code, ok := table[key]; ok {
+			prefix = code
+			continue
+		}
+		w.write(prefix, width)
+		if next == lzwMaxCode {
+			w.write(lzwClear, width)
+			width, next = 9, lzwFirst
+			table = map[int]int{}
+		} else {
+			table[key] = next
+			add()
+		}
+		prefix = int(b)
+	}
+	if prefix >= 0 {
+		w.write(prefix, width)
+		if next < lzwMaxCode {
+			add()
+		}
+	}
+	w.write(lzwEOI, width)
+	return w.flush()
+}
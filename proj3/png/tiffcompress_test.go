@@ -0,0 +1,99 @@
+package png
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// compressData returns test inputs for the compressions: empty, short, with runs, and long random and
+// repetitive data which fills the LZW table several times
+func compressData() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 20000)
+	rng.Read(random)
+	repetitive := make([]byte, 50000)
+	for i := range repetitive {
+		repetitive[i] = byte(i / 7 % 5)
+	}
+	runs := []byte{}
+	for i := 0; i < 300; i++ {
+		runs = append(runs, bytes.Repeat([]byte{byte(i)}, i%131+1)...)
+	}
+	return map[string][]byte{
+		"empty":      {},
+		"single":     {42},
+		"pair":       {7, 7},
+		"short":      []byte("TOBEORNOTTOBEORTOBEORNOT"),
+		"runs":       runs,
+		"random":     random,
+		"repetitive": repetitive,
+	}
+}
+
+func TestPackBitsRoundTrip(t *testing.T) {
+	for name, data := range compressData() {
+		t.Run(name, func(t *testing.T) {
+			got, err := unpackBits(packBits(nil, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip of %d bytes gave %d different bytes", len(data), len(got))
+			}
+		})
+	}
+}
+
+func TestUnpackBits(t *testing.T) {
+	// the example of Apple technical note TN1023, with a no-op header byte added
+	packed := []byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x80, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa}
+	want := []byte{
+		0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0x22,
+		0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+	}
+	got, err := unpackBits(packed)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("unpackBits = %x, %v, want %x", got, err, want)
+	}
+	for _, invalid := range [][]byte{{0x02, 0x01}, {0xfd}} {
+		if _, err := unpackBits(invalid); err == nil {
+			t.Errorf("unpackBits(%x) gave no error", invalid)
+		}
+	}
+}
+
+func TestLZWRoundTrip(t *testing.T) {
+	for name, data := range compressData() {
+		t.Run(name, func(t *testing.T) {
+			got, err := unLZW(lzw(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip of %d bytes gave %d different bytes", len(data), len(got))
+			}
+		})
+	}
+}
+
+func TestUnLZW(t *testing.T) {
+	// 9 bit codes: clear, 'a', 'b', 258 for "ab", then 260, which is not in the table yet and repeats the
+	// previous entry followed by its first byte, and end
+	w := &lzwWriter{}
+	for _, code := range []int{lzwClear, 'a', 'b', 258, 260, lzwEOI} {
+		w.write(code, 9)
+	}
+	got, err := unLZW(w.flush())
+	if want := "abababa"; err != nil || string(got) != want {
+		t.Fatalf("unLZW = %q, %v, want %q", got, err, want)
+	}
+
+	w = &lzwWriter{}
+	for _, code := range []int{lzwClear, 'a', 300} {
+		w.write(code, 9)
+	}
+	if _, err := unLZW(w.flush()); err != errLZW {
+		t.Fatalf("unLZW of an unknown code gave %v, want %v", err, errLZW)
+	}
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"math"
+	"proj3/assign"
+	"proj3/png"
+	"sort"
+)
+
+// assignMaxEntries is the largest cost matrix solved exactly. The Hungarian algorithm takes cubic time
+// in the number of cells, so larger problems are solved approximately by an auction
+const assignMaxEntries = 1 << 20
+
+// featureWorker computes the matching feature of the cells, given by their indices, received from the channel
+func featureWorker(config *Config, cells []*Cell, outImg *png.Image, indices []int, features [][]float64, indexChannel <-chan int, boolChannel chan<- bool) {
+	for {
+		j, more := <-indexChannel
+		if !more {
+			break
+		}
+		i := indices[j]
+		mask := cells[i].mask()
+		features[i] = imageFeature(config, outImg.SubsizeMask(cells[i].Rect, mask), mask)
+		boolChannel <- true
+	}
+}
+
+// costWorker computes the rows of the cost matrix for the cells received from the channel
+func costWorker(matcher *Matcher, features [][]float64, copies int, cost [][]float64, indexChannel <-chan int, boolChannel chan<- bool) {
+	for {
+		i, more := <-indexChannel
+		if !more {
+			break
+		}
+		row := make([]float64, len(matcher.tiles)*copies)
+		for j, tile := range matcher.tiles {
+			dist := featureDistance(matcher.config, features[i], tile.Feature)
+			for c := 0; c < copies; c++ {
+				row[c*len(matcher.tiles)+j] = dist
+			}
+		}
+		cost[i] = row
+		boolChannel <- true
+	}
+}
+
+// bestWorker computes the distance of the closest tile to each cell received from the channel
+func bestWorker(matcher *Matcher, features [][]float64, best []float64, indexChannel <-chan int, boolChannel chan<- bool) {
+	for {
+		i, more := <-indexChannel
+		if !more {
+			break
+		}
+		best[i] = math.Inf(1)
+		for _, tile := range matcher.tiles {
+			best[i] = math.Min(best[i], featureDistance(matcher.config, features[i], tile.Feature))
+		}
+		boolChannel <- true
+	}
+}
+
+// runIndexed runs the worker on the given number of goroutines for every index from 0 to n-1
+func runIndexed(n int, threads int, worker func(indexChannel <-chan int, boolChannel chan<- bool)) {
+	indexChannel := make(chan int, n)
+	boolChannel := make(chan bool, threads)
+
+	// pushes indices to channel
+	for i := 0; i < n; i++ {
+		indexChannel <- i
+	}
+	close(indexChannel)
+
+	// runs the workers
+	for i := 0; i < threads; i++ {
+		go worker(indexChannel, boolChannel)
+	}
+
+	// waiting until all tasks are finished
+	for i := 0; i < n; i++ {
+		<-boolChannel
+	}
+	close(boolChannel)
+}
+
+// cellFeatures computes the matching feature of every cell using the given number of goroutines
+func (matcher *Matcher) cellFeatures(cells []*Cell, ref reference, threads int) [][]float64 {
+	features := make([][]float64, len(cells))
+	ref.visit(cellRects(cells), func(img *png.Image, indices []int) {
+		runIndexed(len(indices), threads, func(indexChannel <-chan int, boolChannel chan<- bool) {
+			featureWorker(matcher.config, cells, img, indices, features, indexChannel, boolChannel)
+		})
+	})
+	return features
+}
+
+// Assign finds the one-to-one assignment of tiles to cells with the minimal total feature distance. If there are
+// fewer tiles than cells, each tile is repeated as few times as possible, but no more than the maximum uses.
+// Cells which do not fit in the uses are left to the regular selection. Those are the cells with the closest
+// best match, which lose the least by not taking part. The cost matrix is built by the given number of goroutines.
+// Problems whose cost matrix would exceed assignMaxEntries are solved by an auction instead, which computes the
+// costs on demand
+func (matcher *Matcher) Assign(cells []*Cell, ref reference, threads int) {
+	copies := matcher.copies(len(cells))
+	features := matcher.cellFeatures(cells, ref, threads)
+
+	// orders the cells by the distance of their closest tile, farthest first, if some are left to the
+	// regular selection. The first rows cells are assigned
+	rows := min(len(cells), len(matcher.tiles)*copies)
+	order := make([]int, len(cells))
+	for i := range order {
+		order[i] = i
+	}
+	if rows < len(cells) {
+		best := make([]float64, len(cells))
+		runIndexed(len(cells), threads, func(indexChannel <-chan int, boolChannel chan<- bool) {
+			bestWorker(matcher, features, best, indexChannel, boolChannel)
+		})
+		sort.SliceStable(order, func(i, j int) bool {
+			return best[order[i]] > best[order[j]]
+		})
+	}
+	order = order[:rows]
+	rowFeatures := make([][]float64, rows)
+	for row, i := range order {
+		rowFeatures[row] = features[i]
+	}
+
+	var columns []int
+	if rows*len(matcher.tiles)*copies <= assignMaxEntries {
+		cost := make([][]float64, rows)
+		runIndexed(rows, threads, func(indexChannel <-chan int, boolChannel chan<- bool) {
+			costWorker(matcher, rowFeatures, copies, cost, indexChannel, boolChannel)
+		})
+		columns = assign.Hungarian(cost)
+	} else {
+		// the auction uses each tile as one column with copies slots
+		columns = assign.Auction(rows, len(matcher.tiles), copies, func(row int, column int) float64 {
+			return featureDistance(matcher.config, rowFeatures[row], matcher.tiles[column].Feature)
+		})
+	}
+
+	matcher.features = features
+	matcher.assignment = make([]int, len(cells))
+	for i := range matcher.assignment {
+		matcher.assignment[i] = -1
+	}
+	for row, column := range columns {
+		tile := column % len(matcher.tiles)
+		cell := cells[order[row]]
+		matcher.assignment[cell.Index] = tile
+		if matcher.usage != nil {
+			matcher.usage.record(tile, cellCenter(matcher.config, cell))
+		}
+	}
+}
+
+// copies computes how many times each tile can be used by the assignment mode
+func (matcher *Matcher) copies(cellCount int) int {
+	copies := (cellCount + len(matcher.tiles) - 1) / len(matcher.tiles)
+	if matcher.config.MaxUses > 0 {
+		copies = min(copies, matcher.config.MaxUses)
+	}
+	return max(copies, 1)
+}
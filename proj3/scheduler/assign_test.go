@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"math"
+	"testing"
+)
+
+// TestAssignLeavesBestMatches checks that the cells left out of the assignment by a usage limit are the cells
+// whose closest tile is the closest, and that the assigned cells keep to the limit
+func TestAssignLeavesBestMatches(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir)
+	for _, maxUses := range []int{1, 3} {
+		config := testConfig(dir)
+		config.Match, config.MaxUses = "assign", maxUses
+		matcher, cells, ref := testLayout(t, config)
+		matcher.Assign(cells, ref, 2)
+
+		uses := map[int]int{}
+		assigned, left := math.Inf(1), math.Inf(-1)
+		for i, cell := range cells {
+			best := math.Inf(1)
+			for _, tile := range matcher.tiles {
+				best = math.Min(best, featureDistance(config, matcher.features[i], tile.Feature))
+			}
+			if tile := matcher.assignment[cell.Index]; tile >= 0 {
+				uses[tile]++
+				assigned = math.Min(assigned, best)
+			} else {
+				left = math.Max(left, best)
+			}
+		}
+		count := 0
+		for tile, n := range uses {
+			if n > maxUses {
+				t.Errorf("max uses %d: tile %d is assigned %d times", maxUses, tile, n)
+			}
+			count += n
+		}
+		if want := min(len(cells), maxUses*len(matcher.tiles)); count != want {
+			t.Errorf("max uses %d: %d cells assigned, want %d", maxUses, count, want)
+		}
+		if left > assigned {
+			t.Errorf("max uses %d: a cell left out has a farther closest tile (%v) than an assigned one (%v)", maxUses, left, assigned)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"proj3/png"
+)
+
+// grout fills the gaps between the tiles with a color, or with a texture image repeated over the output
+type grout struct {
+	color   *color.RGBA64
+	texture *png.Image
+}
+
+// newGrout creates the grout of the configuration, loading its texture image if there is one
+func newGrout(config *Config) (*grout, error) {
+	g := &grout{color: &config.GroutColor}
+	if config.GroutTexture != "" {
+		texture, err := png.Load(config.GroutTexture)
+		if err != nil {
+			return nil, err
+		}
+		g.texture = texture
+	}
+	return g, nil
+}
+
+// at returns the grout color at the position of the output image
+func (g *grout) at(x int, y int) *color.RGBA64 {
+	if g.texture == nil {
+		return g.color
+	}
+	bounds := g.texture.Bounds()
+	x = bounds.Min.X + ((x%bounds.Dx())+bounds.Dx())%bounds.Dx()
+	y = bounds.Min.Y + ((y%bounds.Dy())+bounds.Dy())%bounds.Dy()
+	return png.ColortoRGBA64(g.texture.At(x, y))
+}
+
+// hasGrout checks if the tiles are shrunk to show the grout
+func hasGrout(config *Config) bool {
+	return config.Gap > 0 || config.Corner > 0
+}
+
+// tileSize computes the size of the tile image of a cell of the given size, which is smaller by the gap
+func tileSize(config *Config, size image.Point) (int, int) {
+	return max(size.X-config.Gap, 1), max(size.Y-config.Gap, 1)
+}
+
+// tileShape computes the coverage of the pixels of the cell by its tile, which is the cell shrunk by half of
+// the gap on every side, with rounded corners on rectangular cells. Rectangular cells clipped on the top or left
+// keep the shape of their whole tile window. The coverage of the pixels on the edges
+// of the shape is anti-aliased. Without grout, the shape is the mask of the cell
+func tileShape(config *Config, cell *Cell, mask *image.Alpha) *image.Alpha {
+	if !hasGrout(config) {
+		return mask
+	}
+	inset := float64(config.Gap) / 2
+	width, height := cell.Rect.Dx(), cell.Rect.Dy()
+	shape := image.NewAlpha(image.Rect(0, 0, width, height))
+	coverage := func(x int, y int, dist float64) {
+		shape.Pix[shape.PixOffset(x, y)] = uint8(math.Round(math.Min(math.Max(dist, 0), 1) * 0xff))
+	}
+
+	if mask == nil {
+		// signed distance to a rounded rectangle, negative inside
+		tileRect := image.Rectangle{cell.Origin, cell.Rect.Max}.Sub(cell.Rect.Min)
+		centerX := float64(tileRect.Min.X+tileRect.Max.X) / 2
+		centerY := float64(tileRect.Min.Y+tileRect.Max.Y) / 2
+		halfWidth := float64(tileRect.Dx())/2 - inset
+		halfHeight := float64(tileRect.Dy())/2 - inset
+		radius := math.Max(math.Min(config.Corner, math.Min(halfWidth, halfHeight)), 0)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				qx := math.Abs(float64(x)+0.5-centerX) - halfWidth + radius
+				qy := math.Abs(float64(y)+0.5-centerY) - halfHeight + radius
+				dist := math.Hypot(math.Max(qx, 0), math.Max(qy, 0)) + math.Min(math.Max(qx, qy), 0) - radius
+				coverage(x, y, 0.5-dist)
+			}
+		}
+		return shape
+	}
+
+	// the edge of the mask is half a pixel closer than the nearest pixel outside of it, so the signed
+	// distance to the shrunk shape is inset + 0.5 - dist
+	dists := edgeDistances(mask)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			coverage(x, y, dists[y*width+x]-inset)
+		}
+	}
+	return shape
+}
+
+// edgeDistances computes the euclidean distance of every pixel to the nearest pixel which is not covered by
+// the mask, where the pixels around the mask are not covered. Uses the two pass distance transform of
+// Felzenszwalb and Huttenlocher on the squared distances
+func edgeDistances(mask *image.Alpha) []float64 {
+	bounds := mask.Bounds()
+	width, height := bounds.Dx()+2, bounds.Dy()+2
+	inf := float64(width*width + height*height)
+	dists := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x > 0 && y > 0 && x < width-1 && y < height-1 && png.Covered(mask, bounds.Min.X+x-1, bounds.Min.Y+y-1) {
+				dists[y*width+x] = inf
+			}
+		}
+	}
+	line := make([]float64, max(width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			line[y] = dists[y*width+x]
+		}
+		transformLine(line[:height])
+		for y := 0; y < height; y++ {
+			dists[y*width+x] = line[y]
+		}
+	}
+	for y := 0; y < height; y++ {
+		transformLine(dists[y*width : (y+1)*width])
+	}
+
+	// drops the border around the mask
+	inner := make([]float64, bounds.Dx()*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			inner[y*bounds.Dx()+x] = math.Sqrt(dists[(y+1)*width+x+1])
+		}
+	}
+	return inner
+}
+
+// transformLine replaces the squared distances of a line by their one dimensional distance transform,
+// the lower envelope of the parabolas rooted at every pixel
+func transformLine(line []float64) {
+	n := len(line)
+	values := append([]float64{}, line...)
+	roots := make([]int, n)
+	bounds := make([]float64, n+1)
+	k := 0
+	bounds[0], bounds[1] = math.Inf(-1), math.Inf(1)
+	intersect := func(p int, q int) float64 {
+		return ((values[q] + float64(q*q)) - (values[p] + float64(p*p))) / float64(2*(q-p))
+	}
+	for q := 1; q < n; q++ {
+		s := intersect(roots[k], q)
+		for s <= bounds[k] {
+			k--
+			s = intersect(roots[k], q)
+		}
+		k++
+		roots[k] = q
+		bounds[k] = s
+		bounds[k+1] = math.Inf(1)
+	}
+	k = 0
+	for q := 0; q < n; q++ {
+		for bounds[k+1] < float64(q) {
+			k++
+		}
+		d := q - roots[k]
+		line[q] = float64(d*d) + values[roots[k]]
+	}
+}
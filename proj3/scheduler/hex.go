@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// hexCoord represents the axial coordinates of a hexagon
+type hexCoord struct {
+	Q int
+	R int
+}
+
+// hexAt finds the pointy top hexagon of the given circumradius containing the point, where the
+// hexagon (0, 0) is centered on the origin
+func hexAt(x float64, y float64, radius float64) hexCoord {
+	q := (math.Sqrt(3)/3*x - y/3) / radius
+	r := 2.0 / 3 * y / radius
+	s := -q - r
+
+	// rounds the cube coordinates, then fixes the one with the largest rounding error
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	}
+	return hexCoord{int(rq), int(rr)}
+}
+
+// hexCenter computes the center of the pointy top hexagon of the given circumradius
+func hexCenter(hex hexCoord, radius float64) (float64, float64) {
+	return radius * math.Sqrt(3) * (float64(hex.Q) + float64(hex.R)/2), radius * 1.5 * float64(hex.R)
+}
+
+// hexGrid maps points of the output image to the hexagons of the layout. Flat top hexagons are
+// pointy top hexagons with the axes swapped. The hexagons are stretched across the pointy axis to
+// the proportions of the tiles, and the first hexagon is placed so that it is not clipped
+type hexGrid struct {
+	radius  float64
+	stretch float64
+	flat    bool
+	offset  [2]float64
+}
+
+// newHexGrid creates the hexagon grid of the layout, whose hexagons fit inside the tile images
+func newHexGrid(config *Config) *hexGrid {
+	grid := &hexGrid{flat: config.HexOrient == "flat"}
+	across, along := float64(config.TileWidth), float64(config.TileHeight)
+	if grid.flat {
+		across, along = along, across
+	}
+	grid.radius = along / 2
+	grid.stretch = along / across
+	grid.offset = [2]float64{grid.radius * math.Sqrt(3) / 2 / grid.stretch, grid.radius}
+	if grid.flat {
+		grid.offset[0], grid.offset[1] = grid.offset[1], grid.offset[0]
+	}
+	return grid
+}
+
+// at finds the hexagon containing the point
+func (grid *hexGrid) at(x float64, y float64) hexCoord {
+	x -= grid.offset[0]
+	y -= grid.offset[1]
+	if grid.flat {
+		x, y = y, x
+	}
+	return hexAt(x*grid.stretch, y, grid.radius)
+}
+
+// center computes the center of the hexagon
+func (grid *hexGrid) center(hex hexCoord) (float64, float64) {
+	x, y := hexCenter(hex, grid.radius)
+	x /= grid.stretch
+	if grid.flat {
+		x, y = y, x
+	}
+	return x + grid.offset[0], y + grid.offset[1]
+}
+
+// position computes the column and row of the hexagon, where every other row of pointy top
+// hexagons, or column of flat top hexagons, is shifted by half a hexagon
+func (grid *hexGrid) position(hex hexCoord) (int, int) {
+	col, row := hex.Q+(hex.R-hex.R&1)/2, hex.R
+	if grid.flat {
+		col, row = row, col
+	}
+	return col, row
+}
+
+// hexCells assigns every pixel of the bounds to the hexagon containing its center, so that the cells
+// neither overlap nor leave gaps. Each cell covers the pixels of its hexagon and gets the tile window
+// centered on it. The cells are ordered column by column
+func hexCells(config *Config, bounds image.Rectangle) []*Cell {
+	grid := newHexGrid(config)
+	hexAtPixel := func(x int, y int) hexCoord {
+		return grid.at(float64(x-bounds.Min.X)+0.5, float64(y-bounds.Min.Y)+0.5)
+	}
+	cells := regionCells(bounds, hexAtPixel, func(hex hexCoord) *Cell {
+		col, row := grid.position(hex)
+		cx, cy := grid.center(hex)
+		return &Cell{
+			Col: col,
+			Row: row,
+			Origin: image.Pt(
+				bounds.Min.X+int(math.Round(cx-float64(config.TileWidth)/2)),
+				bounds.Min.Y+int(math.Round(cy-float64(config.TileHeight)/2)),
+			),
+			Size: image.Pt(config.TileWidth, config.TileHeight),
+		}
+	})
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Col != cells[j].Col {
+			return cells[i].Col < cells[j].Col
+		}
+		return cells[i].Row < cells[j].Row
+	})
+	return cells
+}
+
+// hexTileMask masks the pixels of a tile image covered by a hexagon centered on it. The tile image is
+// smaller than the hexagon by the gap
+func hexTileMask(config *Config) *image.Alpha {
+	width, height := tileSize(config, image.Pt(config.TileWidth, config.TileHeight))
+	grid := newHexGrid(config)
+	center := grid.at(float64(width)/2, float64(height)/2)
+	cx, cy := grid.center(center)
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px := float64(x) + 0.5 - float64(width)/2 + cx
+			py := float64(y) + 0.5 - float64(height)/2 + cy
+			if grid.at(px, py) == center {
+				mask.Pix[mask.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return mask
+}
@@ -0,0 +1,290 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"proj3/png"
+)
+
+// Cell represents a tile position in the output image. Index is the position of the cell in the list of cells.
+// Level is the number of times the cell is halved from the tile size, and Col and Row are its position among the
+// cells of that level. Cells which are not rectangles test their pixels with Covers, so that their masks are only
+// built while the cell is matched or rendered. Origin is the position of the tile window in the output image and
+// Size is its size, which is larger than Rect if the cell is clipped, and is the bounding box of Voronoi cells.
+// Rotated cells show their tile turned by 90 degrees
+type Cell struct {
+	Index   int
+	Col     int
+	Row     int
+	Level   int
+	Rect    image.Rectangle
+	Covers  func(x int, y int) bool
+	Origin  image.Point
+	Size    image.Point
+	Rotated bool
+}
+
+// point represents a position in units of tile width and tile height
+type point struct {
+	X float64
+	Y float64
+}
+
+// cellCenter computes the center of the cell in units of tiles. Masked cells are centered on their tile window
+func cellCenter(config *Config, cell *Cell) point {
+	if cell.Covers != nil {
+		return point{
+			float64(2*cell.Origin.X+cell.Size.X) / float64(2*config.TileWidth),
+			float64(2*cell.Origin.Y+cell.Size.Y) / float64(2*config.TileHeight),
+		}
+	}
+	return point{
+		float64(cell.Rect.Min.X+cell.Rect.Max.X) / float64(2*config.TileWidth),
+		float64(cell.Rect.Min.Y+cell.Rect.Max.Y) / float64(2*config.TileHeight),
+	}
+}
+
+// mask masks the pixels of the cell inside Rect. Returns nil if the cell is a rectangle
+func (cell *Cell) mask() *image.Alpha {
+	if cell.Covers == nil {
+		return nil
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, cell.Rect.Dx(), cell.Rect.Dy()))
+	for y := 0; y < cell.Rect.Dy(); y++ {
+		for x := 0; x < cell.Rect.Dx(); x++ {
+			if cell.Covers(cell.Rect.Min.X+x, cell.Rect.Min.Y+y) {
+				mask.Pix[mask.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return mask
+}
+
+// levelSize computes the smallest width and height of the cells at the level
+func levelSize(config *Config, level int) (int, int) {
+	return config.TileWidth >> level, config.TileHeight >> level
+}
+
+// levelSizes lists the sizes of the cells at the level. Halving a cell rounds one half down and the other
+// up, so the cells of a level are the tile size divided by 2^level, rounded either way
+func levelSizes(config *Config, level int) []image.Point {
+	rounded := func(size int) []int {
+		if size%(1<<level) == 0 {
+			return []int{size >> level}
+		}
+		return []int{size >> level, size>>level + 1}
+	}
+	sizes := []image.Point{}
+	for _, width := range rounded(config.TileWidth) {
+		for _, height := range rounded(config.TileHeight) {
+			sizes = append(sizes, image.Pt(width, height))
+		}
+	}
+	return sizes
+}
+
+// levelCount computes the number of levels used by the layout
+func levelCount(config *Config) int {
+	if config.Layout != "quadtree" {
+		return 1
+	}
+	levels := 1
+	for {
+		width, height := levelSize(config, levels)
+		if min(width, height) < max(config.MinTile, 1) {
+			break
+		}
+		levels++
+	}
+	return levels
+}
+
+// layoutCells creates the cells of the layout over the upscaled input image
+func layoutCells(config *Config, ref reference) []*Cell {
+	var cells []*Cell
+	if config.Layout == "hex" {
+		cells = hexCells(config, ref.Bounds())
+	} else if config.Layout == "voronoi" {
+		cells = voronoiCells(config, ref, ref.Bounds())
+	} else if config.Layout == "brick" {
+		cells = brickCells(config, ref.Bounds())
+	} else if config.Layout == "herringbone" {
+		cells = herringboneCells(config, ref.Bounds())
+	} else if config.Layout == "basket" {
+		cells = basketCells(config, ref.Bounds())
+	} else {
+		cells = gridCells(config, ref.Bounds())
+	}
+	if config.Layout == "quadtree" {
+		cells = quadtreeCells(config, ref, cells)
+	}
+	for i, cell := range cells {
+		cell.Index = i
+	}
+	return cells
+}
+
+// gridCells cuts the bounds into tile sized rectangles, column by column
+func gridCells(config *Config, bounds image.Rectangle) []*Cell {
+	xs := gridLines(bounds.Min.X, bounds.Dx(), config.TileWidth, config.GridCols)
+	ys := gridLines(bounds.Min.Y, bounds.Dy(), config.TileHeight, config.GridRows)
+	cells := []*Cell{}
+	for col := 0; col+1 < len(xs); col++ {
+		for row := 0; row+1 < len(ys); row++ {
+			cells = append(cells, &Cell{
+				Col:    col,
+				Row:    row,
+				Rect:   image.Rect(xs[col], ys[row], xs[col+1], ys[row+1]),
+				Origin: image.Pt(xs[col], ys[row]),
+				Size:   image.Pt(config.TileWidth, config.TileHeight),
+			})
+		}
+	}
+	return cells
+}
+
+// gridLines computes the positions of the cell edges along one axis of the given start and length. The
+// cells are spread evenly if their count is given, otherwise they are tile sized and the last one is clipped
+func gridLines(start int, length int, size int, count int) []int {
+	lines := []int{start}
+	if count > 0 {
+		for i := 1; i <= count; i++ {
+			lines = append(lines, start+i*length/count)
+		}
+		return lines
+	}
+	for pos := start + size; pos < start+length; pos += size {
+		lines = append(lines, pos)
+	}
+	return append(lines, start+length)
+}
+
+// regionCells assigns every pixel of the bounds to the region returned by regionAt, so that the cells neither
+// overlap nor leave gaps. The cell of each region is created by newCell, then gets the bounding box of its
+// pixels, which it covers by testing regionAt again. No masks are kept, so that the memory only grows with the
+// number of cells. The cells are returned in no particular order
+func regionCells[K comparable](bounds image.Rectangle, regionAt func(x int, y int) K, newCell func(region K) *Cell) []*Cell {
+	regions := map[K]*Cell{}
+
+	// finds the bounding box of each region, looking the region up only when it changes along a row
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var last *Cell
+		var lastRegion K
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			region := regionAt(x, y)
+			pixel := image.Rect(x, y, x+1, y+1)
+			if last != nil && region == lastRegion {
+				last.Rect = last.Rect.Union(pixel)
+				continue
+			}
+			cell, ok := regions[region]
+			if ok {
+				cell.Rect = cell.Rect.Union(pixel)
+			} else {
+				cell = newCell(region)
+				cell.Rect = pixel
+				cell.Covers = func(x int, y int) bool {
+					return regionAt(x, y) == region
+				}
+				regions[region] = cell
+			}
+			last, lastRegion = cell, region
+		}
+	}
+
+	cells := make([]*Cell, 0, len(regions))
+	for _, cell := range regions {
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// detail measures how much detail the input image has inside the rectangle
+func detail(config *Config, outImg *png.Image, rect image.Rectangle) float64 {
+	if config.SplitMeasure == "edge" {
+		return outImg.EdgeEnergy(rect)
+	}
+	return outImg.StdDev(rect)
+}
+
+// measureDetail measures the detail of the input image inside each rectangle
+func measureDetail(config *Config, ref reference, rects []image.Rectangle) []float64 {
+	details := make([]float64, len(rects))
+	ref.visit(rects, func(img *png.Image, indices []int) {
+		for _, i := range indices {
+			details[i] = detail(config, img, rects[i])
+		}
+	})
+	return details
+}
+
+// quadtreeCells splits each grid cell into four quarters while its detail is above the threshold,
+// down to the minimum tile size. The quarters halve the tile window of the cell, then are clipped
+// like the cell, so they cover it whatever its size. The cells of a level are measured together,
+// then the leaves are listed depth first, keeping the quarters of a cell together
+func quadtreeCells(config *Config, ref reference, gridCells []*Cell) []*Cell {
+	levels := levelCount(config)
+	quarters := map[*Cell][]*Cell{}
+	for pending := gridCells; len(pending) > 0; {
+		splittable := []*Cell{}
+		for _, cell := range pending {
+			if cell.Level+1 < levels {
+				splittable = append(splittable, cell)
+			}
+		}
+		details := measureDetail(config, ref, cellRects(splittable))
+		pending = nil
+		for i, cell := range splittable {
+			if details[i] <= config.SplitThreshold {
+				continue
+			}
+			window := image.Rectangle{cell.Origin, cell.Origin.Add(cell.Size)}
+			xs := []int{window.Min.X, window.Min.X + window.Dx()/2, window.Max.X}
+			ys := []int{window.Min.Y, window.Min.Y + window.Dy()/2, window.Max.Y}
+			for dx := 0; dx < 2; dx++ {
+				for dy := 0; dy < 2; dy++ {
+					quarter := image.Rect(xs[dx], ys[dy], xs[dx+1], ys[dy+1])
+					rect := quarter.Intersect(cell.Rect)
+					if rect.Empty() {
+						continue
+					}
+					quarters[cell] = append(quarters[cell], &Cell{
+						Col:    2*cell.Col + dx,
+						Row:    2*cell.Row + dy,
+						Level:  cell.Level + 1,
+						Rect:   rect,
+						Origin: quarter.Min,
+						Size:   quarter.Size(),
+					})
+				}
+			}
+			pending = append(pending, quarters[cell]...)
+		}
+	}
+
+	cells := []*Cell{}
+	var leaves func(cell *Cell)
+	leaves = func(cell *Cell) {
+		if len(quarters[cell]) == 0 {
+			cells = append(cells, cell)
+			return
+		}
+		for _, quarter := range quarters[cell] {
+			leaves(quarter)
+		}
+	}
+	for _, cell := range gridCells {
+		leaves(cell)
+	}
+	return cells
+}
+
+// cellDistance computes the distance between two cell positions based on the distance norm
+func cellDistance(config *Config, a point, b point) float64 {
+	dx := math.Abs(a.X - b.X)
+	dy := math.Abs(a.Y - b.Y)
+	if config.DistNorm == "euclidean" {
+		return math.Hypot(dx, dy)
+	}
+	return dx + dy
+}
@@ -0,0 +1,338 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"proj3/knn"
+	"proj3/png"
+	"sort"
+)
+
+// Tile represents a resized tile image along with its file name, metadata and precomputed matching feature.
+// Sizes holds the tile image resized for each cell size of the smaller levels of the layout, and Rotated
+// holds it turned by 90 degrees if the layout rotates tiles. Meta describes the loaded image file
+type Tile struct {
+	Name    string
+	Img     *png.Image
+	Sizes   map[image.Point]*png.Image
+	Rotated *png.Image
+	Feature []float64
+	Meta    *png.Metadata
+}
+
+// usesFeatures checks if the matching mode or the refinement needs the tile features
+func usesFeatures(config *Config) bool {
+	return config.Match != "random" || config.Refine > 0 || config.RefineTime > 0
+}
+
+// fitTile resizes a loaded tile image to the tile size based on the fit mode and the tile filter
+func fitTile(config *Config, img *png.Image) *png.Image {
+	mode := config.Fit
+	if mode == "smart" {
+		mode = config.SmartMeasure
+	}
+	width, height := tileSize(config, image.Pt(config.TileWidth, config.TileHeight))
+	return img.Fit(width, height, mode, config.TileFilter, config.FitFill)
+}
+
+// newTile wraps a resized tile image, computing its feature only if the matching mode needs it
+func newTile(config *Config, name string, img *png.Image, meta *png.Metadata) *Tile {
+	tile := &Tile{Name: name, Img: img, Sizes: map[image.Point]*png.Image{}, Meta: meta}
+	for level := 1; level < levelCount(config); level++ {
+		for _, size := range levelSizes(config, level) {
+			width, height := tileSize(config, size)
+			tile.Sizes[size] = img.ResizeFilter(width, height, config.TileFilter)
+		}
+	}
+	if rotatesTiles(config) {
+		tile.Rotated = img.Rotate90()
+	}
+	if usesFeatures(config) {
+		tile.Feature = imageFeature(config, img, tileMask(config))
+	}
+	return tile
+}
+
+// sized returns the tile image shown by the cell, resized for its size. Sizes which are not known when the
+// tiles are loaded, such as those of Voronoi cells, are resized for every cell
+func (tile *Tile) sized(config *Config, cell *Cell) *png.Image {
+	if cell.Rotated {
+		return tile.Rotated
+	}
+	if img, ok := tile.Sizes[cell.Size]; ok {
+		return img
+	}
+	if cell.Size != (image.Point{}) && cell.Size != image.Pt(config.TileWidth, config.TileHeight) {
+		width, height := tileSize(config, cell.Size)
+		return tile.Img.ResizeFilter(width, height, config.TileFilter)
+	}
+	return tile.Img
+}
+
+// tileMask masks the pixels of a tile image which are shown by the cells of the layout. Returns nil if
+// the whole tile image is shown
+func tileMask(config *Config) *image.Alpha {
+	if config.Layout == "hex" {
+		return hexTileMask(config)
+	}
+	return nil
+}
+
+// imageFeature computes the matching feature of the pixels of an image covered by the mask based on the feature type
+func imageFeature(config *Config, img *png.Image, mask *image.Alpha) []float64 {
+	if config.Feature == "hist" {
+		return png.FlattenHistogram(img.HistogramMask(mask))
+	} else if config.Feature == "grid" {
+		return img.GridSignatureMask(config.GridSize, mask)
+	} else if config.ColorDist != "rgb" {
+		mean := img.MeanLabMask(mask)
+		return mean[:]
+	}
+	mean := img.MeanColorMask(mask)
+	return mean[:]
+}
+
+// euclideanFeature checks if the feature distance is euclidean, which allows indexing the tile features
+func euclideanFeature(config *Config) bool {
+	return config.Feature != "hist" && config.ColorDist != "cie94" && config.ColorDist != "ciede2000"
+}
+
+// featureDistance computes the distance between two features based on the feature type and metric
+func featureDistance(config *Config, a []float64, b []float64) float64 {
+	if config.Feature == "hist" {
+		switch config.Metric {
+		case "bhatt":
+			return png.Bhattacharyya(a, b)
+		case "inter":
+			return png.Intersection(a, b)
+		case "emd":
+			return png.EarthMover(a, b)
+		default:
+			return png.ChiSquare(a, b)
+		}
+	}
+	if euclideanFeature(config) {
+		return knn.Distance(a, b)
+	}
+	// sums the squared color differences of every Lab color in the features
+	colorDistance := png.ColorDistances[config.ColorDist]
+	var dist float64
+	for i := 0; i+2 < len(a); i += 3 {
+		d := colorDistance([3]float64(a[i:i+3]), [3]float64(b[i:i+3]))
+		dist += d * d
+	}
+	return dist
+}
+
+// Matcher selects tiles for tile positions, shared by all workers of a scheduler
+type Matcher struct {
+	config     *Config
+	tiles      []*Tile
+	index      *knn.Tree
+	usage      *usage
+	assignment []int
+	features   [][]float64
+}
+
+// newMatcher creates a Matcher, indexing the tile features if the feature distance is euclidean.
+// The tiles are sorted by name, so that their order does not depend on the loading goroutines
+func newMatcher(config *Config, tiles []*Tile) *Matcher {
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].Name < tiles[j].Name
+	})
+	matcher := &Matcher{config: config, tiles: tiles}
+	if usesFeatures(config) && euclideanFeature(config) {
+		features := make([][]float64, len(tiles))
+		for i, tile := range tiles {
+			features[i] = tile.Feature
+		}
+		matcher.index = knn.NewTree(features)
+	}
+	if config.MaxUses > 0 || config.MinDist > 0 {
+		matcher.usage = newUsage(len(tiles))
+	}
+	return matcher
+}
+
+// nearest returns up to k tiles closest to the feature, using the index if one exists
+func (matcher *Matcher) nearest(feature []float64, k int) []knn.Neighbor {
+	if matcher.index != nil {
+		return matcher.index.Nearest(feature, k)
+	}
+	neighbors := []knn.Neighbor{}
+	for i, tile := range matcher.tiles {
+		dist := featureDistance(matcher.config, feature, tile.Feature)
+		if len(neighbors) < k {
+			neighbors = append(neighbors, knn.Neighbor{Index: i, Dist: dist})
+		} else if dist < neighbors[k-1].Dist {
+			neighbors[k-1] = knn.Neighbor{Index: i, Dist: dist}
+		} else {
+			continue
+		}
+		for j := len(neighbors) - 1; j > 0 && neighbors[j].Dist < neighbors[j-1].Dist; j-- {
+			neighbors[j], neighbors[j-1] = neighbors[j-1], neighbors[j]
+		}
+	}
+	return neighbors
+}
+
+// place picks the first candidate tile which satisfies the reuse limits at the cell, then records it.
+// Returns -1 if none of the candidates is allowed
+func (matcher *Matcher) place(cell *Cell, candidates []int) int {
+	pos := cellCenter(matcher.config, cell)
+	matcher.usage.lock.Lock()
+	defer matcher.usage.lock.Unlock()
+	for _, i := range candidates {
+		if !matcher.usage.allowed(matcher.config, i, pos) {
+			continue
+		}
+		matcher.usage.record(i, pos)
+		if matcher.index != nil && matcher.config.MaxUses > 0 && matcher.usage.counts[i] >= matcher.config.MaxUses {
+			matcher.index.Remove(i)
+		}
+		return i
+	}
+	return -1
+}
+
+// rank orders the tiles found by a nearest query. The softmax mode samples the order from a softmax
+// over the distances, scaled by the mean distance above the best one, so that the temperature does
+// not depend on the feature. Other modes keep the distance order
+func (matcher *Matcher) rank(neighbors []knn.Neighbor, rng *rand.Rand) []int {
+	candidates := make([]int, len(neighbors))
+	for i, neighbor := range neighbors {
+		candidates[i] = neighbor.Index
+	}
+	temperature := matcher.config.Temperature
+	if matcher.config.Match != "softmax" || temperature <= 0 || len(neighbors) < 2 {
+		return candidates
+	}
+	var spread float64
+	for _, neighbor := range neighbors {
+		spread += neighbor.Dist - neighbors[0].Dist
+	}
+	spread /= float64(len(neighbors) - 1)
+
+	// sorting by Gumbel perturbed scores samples the order without replacement
+	scores := make([]float64, len(neighbors))
+	for i, neighbor := range neighbors {
+		var logit float64
+		if spread > 0 {
+			logit = -(neighbor.Dist - neighbors[0].Dist) / (spread * temperature)
+		}
+		scores[i] = logit - math.Log(-math.Log(1-rng.Float64()))
+	}
+	sort.Sort(byScore{candidates, scores})
+	return candidates
+}
+
+// byScore sorts candidates by descending score
+type byScore struct {
+	candidates []int
+	scores     []float64
+}
+
+func (s byScore) Len() int           { return len(s.candidates) }
+func (s byScore) Less(i, j int) bool { return s.scores[i] > s.scores[j] }
+func (s byScore) Swap(i, j int) {
+	s.candidates[i], s.candidates[j] = s.candidates[j], s.candidates[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
+
+// choose picks the index of a tile for the cell with the feature based on the matching mode, using the random
+// number generator of the cell. If no tile satisfies the reuse limits, the limits are ignored for the cell
+func (matcher *Matcher) choose(cell *Cell, feature []float64, rng *rand.Rand) int {
+	config := matcher.config
+	if config.Match == "random" || (config.Match == "softmax" && math.IsInf(config.Temperature, 1)) {
+		if matcher.usage != nil {
+			if i := matcher.place(cell, rng.Perm(len(matcher.tiles))); i >= 0 {
+				return i
+			}
+		}
+		return rng.Intn(len(matcher.tiles))
+	}
+	k := 1
+	if config.Match == "softmax" {
+		k = config.TopK
+		if k == 0 {
+			k = len(matcher.tiles)
+		}
+	}
+	candidates := matcher.rank(matcher.nearest(feature, k), rng)
+	if matcher.usage == nil && len(candidates) > 0 {
+		return candidates[0]
+	}
+	if matcher.usage != nil {
+		if i := matcher.place(cell, candidates); i >= 0 {
+			return i
+		}
+		// widens the search in distance order once the ranked candidates are used up
+		for k = max(2*k, 16); ; k *= 2 {
+			neighbors := matcher.nearest(feature, k)
+			candidates = make([]int, len(neighbors))
+			for i, neighbor := range neighbors {
+				candidates[i] = neighbor.Index
+			}
+			if i := matcher.place(cell, candidates); i >= 0 {
+				return i
+			}
+			if len(neighbors) < k {
+				break
+			}
+		}
+	}
+	best := 0
+	bestDist := math.Inf(1)
+	for i, tile := range matcher.tiles {
+		dist := featureDistance(matcher.config, feature, tile.Feature)
+		if dist < bestDist {
+			best = i
+			bestDist = dist
+		}
+	}
+	return best
+}
+
+// Plan picks the tiles of all cells before rendering if the result would otherwise depend on the order
+// the cells are rendered in. This is the case for the assignment mode and the reuse limits. The features
+// of the cells are computed by the given number of goroutines, then the cells are planned in order
+func (matcher *Matcher) Plan(cells []*Cell, ref reference, threads int) {
+	if matcher.config.Match == "assign" {
+		matcher.Assign(cells, ref, threads)
+	} else if matcher.usage != nil {
+		if matcher.config.Match != "random" {
+			matcher.features = matcher.cellFeatures(cells, ref, threads)
+		}
+		matcher.assignment = make([]int, len(cells))
+		for i := range matcher.assignment {
+			matcher.assignment[i] = -1
+		}
+	}
+	if matcher.usage == nil {
+		return
+	}
+	for i, cell := range cells {
+		if matcher.assignment[cell.Index] >= 0 {
+			continue
+		}
+		var feature []float64
+		if matcher.features != nil {
+			feature = matcher.features[i]
+		}
+		matcher.assignment[cell.Index] = matcher.choose(cell, feature, cellRand(matcher.config, cell))
+	}
+}
+
+// Select picks a tile for the cell, whose region in the input image is refImg with the pixels of the cell
+// masked by mask, based on the matching mode. Cells which are already planned get their planned tile
+func (matcher *Matcher) Select(cell *Cell, refImg *png.Image, mask *image.Alpha) *Tile {
+	if matcher.assignment != nil && matcher.assignment[cell.Index] >= 0 {
+		return matcher.tiles[matcher.assignment[cell.Index]]
+	}
+	var feature []float64
+	if matcher.config.Match != "random" {
+		feature = imageFeature(matcher.config, refImg, mask)
+	}
+	return matcher.tiles[matcher.choose(cell, feature, cellRand(matcher.config, cell))]
+}
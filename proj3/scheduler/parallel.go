@@ -2,69 +2,83 @@ package scheduler
 
 import (
 	"fmt"
-	"image"
 	"io/fs"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"proj3/png"
-	"strings"
 	"time"
 )
 
 // tileGenerator generates tile image from directory entry
-func tileGenerator(config *Config, fileChannel <-chan fs.DirEntry, tileChannel chan<- *png.Image) {
+func tileGenerator(config *Config, fileChannel <-chan fs.DirEntry, tileChannel chan<- *Tile) {
 	for {
 		file, more := <-fileChannel
 		if !more {
 			break
 		}
 		filename := file.Name()
-		ext := strings.ToLower(filepath.Ext(filename))
-		if ext != ".png" {
+		if file.IsDir() {
 			tileChannel <- nil
 			continue
 		}
 		tilePath := filepath.Join(config.TilesDir, filename)
-		tileImg, err := png.Load(tilePath)
+		tileImg, meta, err := png.LoadWithMetadata(tilePath)
 		if err != nil {
 			tileChannel <- nil
 			continue
 		}
-		tileImg = tileImg.Resize(config.TileSize, config.TileSize)
-		tileChannel <- tileImg
+		tileImg = fitTile(config, tileImg)
+		tileChannel <- newTile(config, filename, tileImg, meta)
 	}
 }
 
 // mosaicWorker applies color effects to input image in a specific tile position
-func mosaicWorker(config *Config, outImg *png.Image, tileImgs []*png.Image, rectChannel <-chan *image.Rectangle, boolChannel chan<- bool) {
+func mosaicWorker(config *Config, outImg *png.Image, matcher *Matcher, grout *grout, cellChannel <-chan *Cell, boolChannel chan<- bool) {
 	for {
-		bounds, more := <-rectChannel
+		cell, more := <-cellChannel
 		if !more {
 			break
 		}
-		refImg := outImg.Subsize(*bounds)
-		tileImg := tileImgs[rand.Intn(len(tileImgs))]
-		colorTileImg := tileImg.ColorTransfer(refImg)
-		for x := 0; x < bounds.Dx(); x++ {
-			for y := 0; y < bounds.Dy(); y++ {
-				blendTileColor := png.ColorBlend(
-					png.ColortoRGBA64(tileImg.At(x, y)),
-					png.ColortoRGBA64(colorTileImg.At(x, y)),
-					config.Blendin,
-				)
-				outColor := png.ColorBlend(
-					png.ColortoRGBA64(outImg.At(x+bounds.Min.X, y+bounds.Min.Y)),
-					blendTileColor,
-					config.Intensity,
-				)
-				outImg.Set(x+bounds.Min.X, y+bounds.Min.Y, outColor)
-			}
+		boolChannel <- createMosaic(config, cell, outImg, matcher, grout)
+	}
+}
+
+// refineWorker refines the regions received from the channel
+func refineWorker(refiner *refiner, regionChannel <-chan *region, boolChannel chan<- bool) {
+	for {
+		reg, more := <-regionChannel
+		if !more {
+			break
 		}
-		boolChannel <- true
+		boolChannel <- refiner.refineRegion(reg)
 	}
 }
 
+// refineParallel runs the refinement, refining the regions of the same color using channel
+func refineParallel(config *Config, refiner *refiner) {
+	refiner.run(func(regions []*region) {
+		regionChannel := make(chan *region, len(regions))
+		boolChannel := make(chan bool, config.Threads)
+
+		// pushes regions to channel
+		for _, reg := range regions {
+			regionChannel <- reg
+		}
+		close(regionChannel)
+
+		// runs the refine worker
+		for i := 0; i < config.Threads; i++ {
+			go refineWorker(refiner, regionChannel, boolChannel)
+		}
+
+		// waiting until all regions are refined
+		for i := 0; i < len(regions); i++ {
+			<-boolChannel
+		}
+		close(boolChannel)
+	})
+}
+
 // RunParallel runs the sequential version of the mosaic collage generator using channel
 func RunParallel(config *Config) {
 	// First part: creating upscaled input image and resized tile images
@@ -76,14 +90,15 @@ func RunParallel(config *Config) {
 	ErrorCheck(err)
 	files, err := os.ReadDir(config.TilesDir)
 	ErrorCheck(err)
+	grout, err := newGrout(config)
+	ErrorCheck(err)
 
-	outImg := inImg.Resize(inImg.Bounds().Dx()*config.Upscale, inImg.Bounds().Dy()*config.Upscale)
-	bounds := outImg.Bounds()
+	ref := newReference(config, inImg)
 
-	tileImgs := []*png.Image{}
+	tiles := []*Tile{}
 
 	fileChannel := make(chan fs.DirEntry, len(files))
-	tileChannel := make(chan *png.Image, config.Threads)
+	tileChannel := make(chan *Tile, config.Threads)
 
 	// pushes tile generating tasks to a channel
 	for _, file := range files {
@@ -98,12 +113,13 @@ func RunParallel(config *Config) {
 
 	// extracting the result into an array
 	for i := 0; i < len(files); i++ {
-		tileImg := <-tileChannel
-		if tileImg != nil {
-			tileImgs = append(tileImgs, tileImg)
+		tile := <-tileChannel
+		if tile != nil {
+			tiles = append(tiles, tile)
 		}
 	}
 	close(tileChannel)
+	matcher := newMatcher(config, tiles)
 
 	endTime = time.Since(startTime).Seconds()
 	fmt.Printf("%.2f\n", endTime)
@@ -111,37 +127,33 @@ func RunParallel(config *Config) {
 	// Second part: applying color transfer to tile images, then add it input image position
 	startTime = time.Now()
 
-	rects := []*image.Rectangle{}
-	for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += config.TileSize {
-		for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += config.TileSize {
-			x1 := min(x0+config.TileSize, bounds.Max.X)
-			y1 := min(y0+config.TileSize, bounds.Max.Y)
-			rect := image.Rect(x0, y0, x1, y1)
-			rects = append(rects, &rect)
-		}
+	cells := layoutCells(config, ref)
+	matcher.Plan(cells, ref, config.Threads)
+	if config.Refine > 0 || config.RefineTime > 0 {
+		refineParallel(config, newRefiner(matcher, cells, ref, config.Threads))
 	}
 
-	rectChannel := make(chan *image.Rectangle, len(rects))
-	boolChannel := make(chan bool, config.Threads)
-
-	// pushes tile positions to channel
-	for _, rect := range rects {
-		rectChannel <- rect
-	}
-	close(rectChannel)
+	ErrorCheck(renderOutput(config, ref, cells, config.Threads, func(cells []*Cell, outImg *png.Image) {
+		cellChannel := make(chan *Cell, len(cells))
+		boolChannel := make(chan bool, config.Threads)
 
-	// runs the mosaic worker
-	for i := 0; i < config.Threads; i++ {
-		go mosaicWorker(config, outImg, tileImgs, rectChannel, boolChannel)
-	}
+		// pushes tile positions to channel
+		for _, cell := range cells {
+			cellChannel <- cell
+		}
+		close(cellChannel)
 
-	// waiting until all tasks are finished
-	for i := 0; i < len(rects); i++ {
-		<-boolChannel
-	}
-	close(boolChannel)
+		// runs the mosaic worker
+		for i := 0; i < config.Threads; i++ {
+			go mosaicWorker(config, outImg, matcher, grout, cellChannel, boolChannel)
+		}
 
-	outImg.Save(config.OutImg)
+		// waiting until all tasks are finished
+		for i := 0; i < len(cells); i++ {
+			<-boolChannel
+		}
+		close(boolChannel)
+	}))
 
 	endTime = time.Since(startTime).Seconds()
 	fmt.Printf("%.2f\n", endTime)
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// rotatesTiles checks if the layout places tiles turned by 90 degrees
+func rotatesTiles(config *Config) bool {
+	return config.Layout == "herringbone" || config.Layout == "basket"
+}
+
+// brickCells lays tile sized rectangles in rows, where each row is shifted by the row offset, a fraction
+// of the tile width, from the previous one. The rectangles clipped by the bounds keep their tile window
+func brickCells(config *Config, bounds image.Rectangle) []*Cell {
+	ys := gridLines(bounds.Min.Y, bounds.Dy(), config.TileHeight, config.GridRows)
+	cells := []*Cell{}
+	for row := 0; row+1 < len(ys); row++ {
+		_, fraction := math.Modf(float64(row) * config.RowOffset)
+		shift := int(math.Round(fraction * float64(config.TileWidth)))
+		for col, x0 := 0, bounds.Min.X-shift; x0 < bounds.Max.X; col, x0 = col+1, x0+config.TileWidth {
+			rect := image.Rect(x0, ys[row], x0+config.TileWidth, ys[row+1]).Intersect(bounds)
+			if rect.Empty() {
+				continue
+			}
+			cells = append(cells, &Cell{Col: col, Row: row, Rect: rect, Origin: image.Pt(x0, ys[row])})
+		}
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		return cells[i].Col < cells[j].Col
+	})
+	return cells
+}
+
+// patternUnit computes the short side of the tiles of a pattern and how many short sides make a long side.
+// The long side is taken to be a whole number of short sides
+func patternUnit(config *Config) (int, int) {
+	short, long := min(config.TileWidth, config.TileHeight), max(config.TileWidth, config.TileHeight)
+	return short, max(1, int(math.Round(float64(long)/float64(short))))
+}
+
+// patternCell clips a rectangle of a pattern by the bounds, returning nil if nothing is left. The tiles are
+// laid lengthwise along the wide side of the tile size, so tiles along the other side are rotated
+func patternCell(config *Config, bounds image.Rectangle, rect image.Rectangle, col int, row int) *Cell {
+	clipped := rect.Intersect(bounds)
+	if clipped.Empty() {
+		return nil
+	}
+	wide := config.TileWidth >= config.TileHeight
+	return &Cell{Col: col, Row: row, Rect: clipped, Origin: rect.Min, Rotated: (rect.Dx() >= rect.Dy()) != wide}
+}
+
+// herringboneCells lays the tiles in a straight herringbone, where each vertical tile stands on the left
+// end of a horizontal tile and the pairs climb in steps of one short side. For tiles of k x 1 short sides,
+// the pairs repeat along (1, 1) and (k, -k)
+func herringboneCells(config *Config, bounds image.Rectangle) []*Cell {
+	unit, k := patternUnit(config)
+	horizontal := image.Rect(0, 0, k*unit, unit)
+	vertical := image.Rect(0, unit, unit, (k+1)*unit)
+
+	// the pair at steps a, b is placed at ((a + k b) unit, (a - k b) unit), so the pairs covering the
+	// bounds are found by inverting the corners with a margin of one pair
+	x0, y0 := float64(bounds.Min.X)/float64(unit), float64(bounds.Min.Y)/float64(unit)
+	x1, y1 := float64(bounds.Max.X)/float64(unit), float64(bounds.Max.Y)/float64(unit)
+	minA, maxA := int(math.Floor((x0+y0)/2))-k-1, int(math.Ceil((x1+y1)/2))+1
+	minB, maxB := int(math.Floor((x0-y1)/float64(2*k)))-1, int(math.Ceil((x1-y0)/float64(2*k)))+1
+
+	cells := []*Cell{}
+	for a := minA; a <= maxA; a++ {
+		for b := minB; b <= maxB; b++ {
+			offset := image.Pt((a+k*b)*unit, (a-k*b)*unit)
+			if cell := patternCell(config, bounds, horizontal.Add(offset), a, 2*b); cell != nil {
+				cells = append(cells, cell)
+			}
+			if cell := patternCell(config, bounds, vertical.Add(offset), a, 2*b+1); cell != nil {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	sortCells(cells)
+	return cells
+}
+
+// basketCells lays the tiles in a basket weave of square blocks of one long side, each holding parallel
+// tiles which alternate between horizontal and vertical like a checkerboard
+func basketCells(config *Config, bounds image.Rectangle) []*Cell {
+	unit, k := patternUnit(config)
+	block := k * unit
+	cells := []*Cell{}
+	for i := 0; bounds.Min.X+i*block < bounds.Max.X; i++ {
+		for j := 0; bounds.Min.Y+j*block < bounds.Max.Y; j++ {
+			corner := image.Pt(bounds.Min.X+i*block, bounds.Min.Y+j*block)
+			for t := 0; t < k; t++ {
+				rect := image.Rect(0, t*unit, block, (t+1)*unit)
+				if (i+j)%2 == 1 {
+					rect = image.Rect(t*unit, 0, (t+1)*unit, block)
+				}
+				if cell := patternCell(config, bounds, rect.Add(corner), i, j*k+t); cell != nil {
+					cells = append(cells, cell)
+				}
+			}
+		}
+	}
+	sortCells(cells)
+	return cells
+}
+
+// sortCells orders the cells column by column by the top left corner of their rectangle
+func sortCells(cells []*Cell) {
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Rect.Min.X != cells[j].Rect.Min.X {
+			return cells[i].Rect.Min.X < cells[j].Rect.Min.X
+		}
+		return cells[i].Rect.Min.Y < cells[j].Rect.Min.Y
+	})
+}
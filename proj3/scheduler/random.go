@@ -0,0 +1,22 @@
+package scheduler
+
+import "math/rand"
+
+// mix hashes the values into a 64-bit seed with the splitmix64 finalizer
+func mix(values ...int64) int64 {
+	var h uint64
+	for _, v := range values {
+		h ^= uint64(v)
+		h += 0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+	}
+	return int64(h)
+}
+
+// cellRand creates the random number generator of a cell. Its stream only depends on the seed and
+// the cell position, so the result does not depend on which goroutine renders the cell
+func cellRand(config *Config, cell *Cell) *rand.Rand {
+	return rand.New(rand.NewSource(mix(config.Seed, int64(cell.Col), int64(cell.Row), int64(cell.Level))))
+}
@@ -0,0 +1,397 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// refineSweeps is the number of sweeps over all regions when the refinement has an iteration budget
+const refineSweeps = 16
+
+// refineBatch is the number of iterations between temperature updates and time budget checks
+const refineBatch = 64
+
+// refineCandidates is the number of closest tiles considered when replacing the tile of a cell
+const refineCandidates = 8
+
+// region represents a block of cells which is refined by one goroutine at a time.
+// Under a usage limit, a region can only take the uses of tiles in its quota, which is
+// shared out before each phase. The uses it gives back return to its quota, and the
+// counts are only updated after the phase, so the result does not depend on the goroutine timing.
+// offset is the number of cells of the regions before it, which places its share of the iterations.
+// delta sums the energy changes of the moves taken during the phase, and moves counts the moves tried
+type region struct {
+	cells  []int
+	offset int
+	rng    *rand.Rand
+	quota  map[int]int
+	change map[int]int
+	delta  float64
+	moves  int
+}
+
+// refiner improves a planned tile assignment by simulated annealing, minimizing the total
+// feature distance plus a penalty for each pair of neighbouring cells sharing a tile.
+// The cells are split into square regions colored like a 2x2 checkerboard. Regions of the
+// same color never touch each other's neighbours, so they can be refined concurrently.
+// With an iteration budget, the result only depends on the seed.
+type refiner struct {
+	matcher    *Matcher
+	features   [][]float64
+	assignment []int
+	candidates [][]int
+	neighbors  [][]int
+	centers    []point
+	counts     []int
+	limit      int
+	wanting    [4][][]*region
+	penalty    float64
+	startTemp  float64
+	colors     [4][]*region
+	sweeps     int
+	sweepIters int
+	sweep      int
+	moves      int
+	start      time.Time
+}
+
+// newRefiner prepares the refinement of the cells. If the cells are not planned yet,
+// the initial assignment is made by the matching mode, one cell at a time
+func newRefiner(matcher *Matcher, cells []*Cell, ref reference, threads int) *refiner {
+	config := matcher.config
+	r := &refiner{matcher: matcher, start: time.Now()}
+
+	r.features = matcher.features
+	if r.features == nil {
+		r.features = matcher.cellFeatures(cells, ref, threads)
+	}
+
+	// makes the initial assignment
+	r.assignment = make([]int, len(cells))
+	r.counts = make([]int, len(matcher.tiles))
+	r.candidates = make([][]int, len(cells))
+	var totalCost float64
+	for i, cell := range cells {
+		tile := -1
+		if matcher.assignment != nil {
+			tile = matcher.assignment[i]
+		}
+		if tile < 0 {
+			tile = matcher.choose(cell, r.features[i], cellRand(config, cell))
+		}
+		r.assignment[i] = tile
+		r.counts[tile]++
+		totalCost += r.cost(i, tile)
+		for _, neighbor := range matcher.nearest(r.features[i], refineCandidates) {
+			r.candidates[i] = append(r.candidates[i], neighbor.Index)
+		}
+	}
+	if config.MaxUses > 0 {
+		r.limit = config.MaxUses
+	}
+	if config.Match == "assign" {
+		r.limit = matcher.copies(len(cells))
+	}
+	if len(cells) > 0 {
+		r.startTemp = totalCost / float64(len(cells))
+	}
+	r.penalty = config.RefinePenalty * r.startTemp
+
+	// finds the neighbours of each cell, which are the cells closer than the minimum repeat distance,
+	// or the surrounding cells if it is shorter, looking only into the nearby tile sized buckets
+	reach := math.Max(config.MinDist, 1.5)
+	r.centers = make([]point, len(cells))
+	centers := r.centers
+	buckets := map[image.Point][]int{}
+	bucketOf := func(p point, side float64) image.Point {
+		return image.Pt(int(math.Floor(p.X/side)), int(math.Floor(p.Y/side)))
+	}
+	for i, cell := range cells {
+		centers[i] = cellCenter(config, cell)
+		key := bucketOf(centers[i], 1)
+		buckets[key] = append(buckets[key], i)
+	}
+	span := int(math.Ceil(reach))
+	r.neighbors = make([][]int, len(cells))
+	for i := range cells {
+		key := bucketOf(centers[i], 1)
+		for dy := -span; dy <= span; dy++ {
+			for dx := -span; dx <= span; dx++ {
+				for _, j := range buckets[key.Add(image.Pt(dx, dy))] {
+					if j == i {
+						continue
+					}
+					if config.MinDist > 1.5 {
+						if cellDistance(config, centers[i], centers[j]) >= config.MinDist {
+							continue
+						}
+					} else if math.Abs(centers[i].X-centers[j].X) >= 1.5 || math.Abs(centers[i].Y-centers[j].Y) >= 1.5 {
+						continue
+					}
+					r.neighbors[i] = append(r.neighbors[i], j)
+				}
+			}
+		}
+	}
+
+	// splits the cells into regions wider than the neighbourhood
+	side := max(8, span+1)
+	regions := map[image.Point]*region{}
+	for i := range cells {
+		key := bucketOf(centers[i], float64(side))
+		reg, found := regions[key]
+		if !found {
+			reg = &region{
+				rng:    rand.New(rand.NewSource(mix(config.Seed, int64(key.X), int64(key.Y), -1))),
+				quota:  map[int]int{},
+				change: map[int]int{},
+			}
+			regions[key] = reg
+			color := (key.X & 1) + 2*(key.Y&1)
+			r.colors[color] = append(r.colors[color], reg)
+		}
+		reg.cells = append(reg.cells, i)
+	}
+
+	// under a usage limit, finds the regions of each color which can take each tile, as a region only
+	// takes more uses of a tile by replacing the tile of a cell with one of its candidates
+	if r.limit > 0 {
+		for color, regions := range r.colors {
+			r.wanting[color] = make([][]*region, len(matcher.tiles))
+			for _, reg := range regions {
+				wanted := map[int]bool{}
+				for _, i := range reg.cells {
+					for _, tile := range r.candidates[i] {
+						if !wanted[tile] {
+							wanted[tile] = true
+							r.wanting[color][tile] = append(r.wanting[color][tile], reg)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	offset := 0
+	for _, regions := range r.colors {
+		for _, reg := range regions {
+			reg.offset = offset
+			offset += len(reg.cells)
+		}
+	}
+	if config.Refine > 0 {
+		r.sweeps = min(refineSweeps, config.Refine)
+	}
+	return r
+}
+
+// split computes the share of total of the range from from to from+size of length, rounded so that the
+// shares of adjacent ranges add up to total
+func split(total int, from int, size int, length int) int {
+	return total*(from+size)/length - total*from/length
+}
+
+// sweepIterations computes the number of iterations of the sweep. An iteration budget is split over the
+// sweeps, otherwise each sweep tries four moves per cell
+func (r *refiner) sweepIterations(sweep int) int {
+	if r.matcher.config.Refine > 0 {
+		return split(r.matcher.config.Refine, sweep, 1, r.sweeps)
+	}
+	return 4 * len(r.assignment)
+}
+
+// cost computes the feature distance between a cell and a tile
+func (r *refiner) cost(cell int, tile int) float64 {
+	return featureDistance(r.matcher.config, r.features[cell], r.matcher.tiles[tile].Feature)
+}
+
+// duplicates counts the neighbours of a cell which use the tile, ignoring the excluded cell
+func (r *refiner) duplicates(cell int, tile int, excluded int) float64 {
+	count := 0
+	for _, neighbor := range r.neighbors[cell] {
+		if neighbor != excluded && r.assignment[neighbor] == tile {
+			count++
+		}
+	}
+	return float64(count)
+}
+
+// conflicts checks if the tile is used by a cell closer to the cell than the minimum repeat distance,
+// ignoring the excluded cell
+func (r *refiner) conflicts(cell int, tile int, excluded int) bool {
+	config := r.matcher.config
+	if config.MinDist <= 0 {
+		return false
+	}
+	for _, neighbor := range r.neighbors[cell] {
+		if neighbor != excluded && r.assignment[neighbor] == tile && cellDistance(config, r.centers[cell], r.centers[neighbor]) < config.MinDist {
+			return true
+		}
+	}
+	return false
+}
+
+// share gives out the free uses of each tile to the quotas of the regions of the color which can take it,
+// in turns starting from a region which moves every sweep
+func (r *refiner) share(color int) {
+	for tile, regions := range r.wanting[color] {
+		free := r.limit - r.counts[tile]
+		if free <= 0 || len(regions) == 0 {
+			continue
+		}
+		first := (tile + r.sweep) % len(regions)
+		for k, reg := range regions {
+			uses := free / len(regions)
+			if (k-first+len(regions))%len(regions) < free%len(regions) {
+				uses++
+			}
+			if uses > 0 {
+				reg.quota[tile] = uses
+			}
+		}
+	}
+}
+
+// settle applies the uses taken and given back by the regions of the color to the counts, and empties their quotas.
+// Returns the energy change of the phase
+func (r *refiner) settle(color int) float64 {
+	var delta float64
+	for _, reg := range r.colors[color] {
+		for tile, change := range reg.change {
+			r.counts[tile] += change
+		}
+		clear(reg.quota)
+		clear(reg.change)
+		delta += reg.delta
+		r.moves += reg.moves
+		reg.delta, reg.moves = 0, 0
+	}
+	return delta
+}
+
+// reserve takes one more use of the tile from the quota of the region
+func (r *refiner) reserve(reg *region, tile int) bool {
+	if r.limit == 0 {
+		return true
+	}
+	if reg.quota[tile] == 0 {
+		return false
+	}
+	reg.quota[tile]--
+	reg.change[tile]++
+	return true
+}
+
+// release gives back one use of the tile to the quota of the region
+func (r *refiner) release(reg *region, tile int) {
+	if r.limit > 0 {
+		reg.quota[tile]++
+		reg.change[tile]--
+	}
+}
+
+// finished checks if the iteration or time budget is used up before the sweep
+func (r *refiner) finished(sweep int) bool {
+	config := r.matcher.config
+	if config.Refine > 0 && sweep >= r.sweeps {
+		return true
+	}
+	return config.RefineTime > 0 && time.Since(r.start) >= config.RefineTime
+}
+
+// temperature computes the annealing temperature from the progress through the budget
+func (r *refiner) temperature(local float64) float64 {
+	config := r.matcher.config
+	var progress float64
+	if config.Refine > 0 {
+		progress = (float64(r.sweep) + local) / float64(r.sweeps)
+	}
+	if config.RefineTime > 0 {
+		progress = max(progress, float64(time.Since(r.start))/float64(config.RefineTime))
+	}
+	return r.startTemp * math.Pow(1e-3, min(progress, 1))
+}
+
+// accept decides if a move changing the energy by delta is taken
+func accept(delta float64, temp float64, rng *rand.Rand) bool {
+	return delta <= 0 || (temp > 0 && rng.Float64() < math.Exp(-delta/temp))
+}
+
+// step tries one random move inside the region: replacing the tile of a cell by one of its
+// closest tiles, or swapping the tiles of two cells. Moves repeating a tile closer than the
+// minimum repeat distance are never taken
+func (r *refiner) step(reg *region, temp float64) {
+	rng := reg.rng
+	i := reg.cells[rng.Intn(len(reg.cells))]
+	a := r.assignment[i]
+	if rng.Intn(2) == 0 && len(r.candidates[i]) > 0 {
+		b := r.candidates[i][rng.Intn(len(r.candidates[i]))]
+		if a == b || r.conflicts(i, b, -1) {
+			return
+		}
+		delta := r.cost(i, b) - r.cost(i, a) + r.penalty*(r.duplicates(i, b, -1)-r.duplicates(i, a, -1))
+		if !accept(delta, temp, rng) || !r.reserve(reg, b) {
+			return
+		}
+		r.release(reg, a)
+		r.assignment[i] = b
+		reg.delta += delta
+		return
+	}
+	j := reg.cells[rng.Intn(len(reg.cells))]
+	b := r.assignment[j]
+	if a == b || r.conflicts(i, b, j) || r.conflicts(j, a, i) {
+		return
+	}
+	delta := r.cost(i, b) + r.cost(j, a) - r.cost(i, a) - r.cost(j, b)
+	delta += r.penalty * (r.duplicates(i, b, j) - r.duplicates(i, a, j) + r.duplicates(j, a, i) - r.duplicates(j, b, i))
+	if accept(delta, temp, rng) {
+		r.assignment[i], r.assignment[j] = b, a
+		reg.delta += delta
+	}
+}
+
+// refineRegion runs the share of the current sweep's iterations that belongs to the region
+func (r *refiner) refineRegion(reg *region) bool {
+	iterations := split(r.sweepIters, reg.offset, len(reg.cells), len(r.assignment))
+	for n := 0; n < iterations; n += refineBatch {
+		if r.matcher.config.RefineTime > 0 && time.Since(r.start) >= r.matcher.config.RefineTime {
+			break
+		}
+		temp := r.temperature(float64(n) / float64(iterations))
+		for k := 0; k < min(refineBatch, iterations-n); k++ {
+			r.step(reg, temp)
+			reg.moves++
+		}
+	}
+	return true
+}
+
+// run refines sweep by sweep until the budget is used up. Each sweep passes the regions
+// of each color to runPhase, which must refine all of them before returning.
+// The assignment with the lowest energy after a sweep, or the initial one, is then used by the matcher
+func (r *refiner) run(runPhase func(regions []*region)) {
+	best := append([]int{}, r.assignment...)
+	if len(r.assignment) > 0 {
+		var energy, bestEnergy float64
+		for sweep := 0; !r.finished(sweep); sweep++ {
+			r.sweep = sweep
+			r.sweepIters = r.sweepIterations(sweep)
+			for color := 0; color < 4; color++ {
+				if len(r.colors[color]) == 0 {
+					continue
+				}
+				r.share(color)
+				runPhase(r.colors[color])
+				energy += r.settle(color)
+			}
+			if energy < bestEnergy {
+				bestEnergy = energy
+				copy(best, r.assignment)
+			}
+		}
+	}
+	r.matcher.assignment = best
+}
@@ -0,0 +1,67 @@
+package scheduler
+
+import "testing"
+
+// testRefiner prepares the refinement of the fixture of the config, planned as by the schedulers
+func testRefiner(t *testing.T, config *Config) *refiner {
+	t.Helper()
+	matcher, cells, ref := testLayout(t, config)
+	matcher.Plan(cells, ref, 1)
+	return newRefiner(matcher, cells, ref, 1)
+}
+
+// energy computes the energy minimized by the refiner for the assignment: the feature distances plus the
+// penalty for each pair of neighbouring cells sharing a tile
+func energy(r *refiner, assignment []int) float64 {
+	saved := r.assignment
+	r.assignment = assignment
+	defer func() { r.assignment = saved }()
+	var total float64
+	for i, tile := range assignment {
+		total += r.cost(i, tile) + r.penalty*r.duplicates(i, tile, -1)/2
+	}
+	return total
+}
+
+func TestRefine(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir)
+	tests := []struct {
+		name   string
+		refine int
+		apply  func(config *Config)
+	}{
+		{"one move", 1, func(config *Config) {}},
+		{"fewer moves than sweeps", 7, func(config *Config) {}},
+		{"fewer moves than cells", 100, func(config *Config) {}},
+		{"uneven budget", 2401, func(config *Config) {}},
+		{"large budget", 20000, func(config *Config) {}},
+		{"min-dist", 5000, func(config *Config) { config.MinDist = 2 }},
+		{"reuse limit", 5000, func(config *Config) { config.Match = "best"; config.MaxUses = 40 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(dir)
+			config.TileWidth, config.TileHeight = 4, 4
+			config.Refine = test.refine
+			test.apply(config)
+			r := testRefiner(t, config)
+			if cells := len(r.assignment); cells < 400 {
+				t.Fatalf("only %d cells", cells)
+			}
+			before := energy(r, append([]int{}, r.assignment...))
+			r.run(func(regions []*region) {
+				for _, reg := range regions {
+					r.refineRegion(reg)
+				}
+			})
+			if r.moves != test.refine {
+				t.Errorf("%d moves run, want %d", r.moves, test.refine)
+			}
+			after := energy(r, r.matcher.assignment)
+			if after > before || (test.refine >= 2000 && after >= before) {
+				t.Errorf("energy %v after refinement, %v before", after, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"image"
+	"proj3/png"
+)
+
+// createMosaic applies color effects to input image in a specific tile position
+func createMosaic(config *Config, cell *Cell, outImg *png.Image, matcher *Matcher, grout *grout) bool {
+	bounds := cell.Rect
+
+	// extracts subimage at tile position, reading only the pixels of the cell
+	mask := cell.mask()
+	refImg := outImg.SubsizeMask(bounds, mask)
+
+	// selects an image from tiles based on the matching mode, sized for the cell
+	tileImg := matcher.Select(cell, refImg, mask).sized(config, cell)
+
+	// aligns the tile image with the cell if the cell is not a rectangle, is clipped on the top or left,
+	// or the tile is shrunk by the gap
+	inset := config.Gap / 2
+	window := bounds.Sub(cell.Origin.Add(image.Pt(inset, inset)))
+	if mask != nil || window.Min != (image.Point{}) || !window.In(tileImg.Bounds()) {
+		tileImg = tileImg.SubsizeExtend(window)
+	}
+
+	// applies color transfer to the tile image based on imput image
+	shape := tileShape(config, cell, mask)
+	colorTileImg := tileImg.ColorTransferMask(refImg, shape)
+
+	// updates colored tile image to input image with weights, showing the grout around the tile shape
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			if !png.Covered(mask, x, y) {
+				continue
+			}
+			coverage := 1.0
+			if shape != nil {
+				coverage = float64(shape.AlphaAt(x, y).A) / 0xff
+			}
+			if coverage == 0 {
+				outImg.Set(x+bounds.Min.X, y+bounds.Min.Y, grout.at(x+bounds.Min.X, y+bounds.Min.Y))
+				continue
+			}
+			blendTileColor := png.ColorBlend(
+				png.ColortoRGBA64(tileImg.At(x, y)),
+				png.ColortoRGBA64(colorTileImg.At(x, y)),
+				config.Blendin,
+			)
+			outColor := png.ColorBlend(
+				png.ColortoRGBA64(outImg.At(x+bounds.Min.X, y+bounds.Min.Y)),
+				blendTileColor,
+				config.Intensity,
+			)
+			if coverage < 1 {
+				outColor = png.ColorBlend(grout.at(x+bounds.Min.X, y+bounds.Min.Y), outColor, coverage)
+			}
+			outImg.Set(x+bounds.Min.X, y+bounds.Min.Y, outColor)
+		}
+	}
+	return true
+}
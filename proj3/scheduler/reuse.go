@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"sync"
+)
+
+// usage tracks how many times and where each tile is placed. It is shared by all workers
+type usage struct {
+	lock      sync.Mutex
+	counts    []int
+	positions [][]point
+}
+
+// newUsage creates an empty usage record for the tiles
+func newUsage(tileCount int) *usage {
+	return &usage{
+		counts:    make([]int, tileCount),
+		positions: make([][]point, tileCount),
+	}
+}
+
+// allowed checks if the tile can be placed at the position without breaking the reuse limits.
+// The lock must be held by the caller
+func (u *usage) allowed(config *Config, tile int, pos point) bool {
+	if config.MaxUses > 0 && u.counts[tile] >= config.MaxUses {
+		return false
+	}
+	if config.MinDist > 0 {
+		for _, other := range u.positions[tile] {
+			if cellDistance(config, pos, other) < config.MinDist {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// record registers a placement of the tile at the position. The lock must be held by the caller
+func (u *usage) record(tile int, pos point) {
+	u.counts[tile]++
+	u.positions[tile] = append(u.positions[tile], pos)
+}
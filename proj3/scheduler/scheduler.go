@@ -2,19 +2,63 @@ package scheduler
 
 import (
 	"fmt"
+	"image/color"
 	"os"
+	"proj3/png"
+	"time"
 )
 
 type Config struct {
-	InImg     string
-	OutImg    string
-	TilesDir  string
-	TileSize  int
-	RunMode   string
-	Threads   int
-	Upscale   int
-	Intensity float64
-	Blendin   float64
+	InImg          string
+	OutImg         string
+	Encode         png.EncodeOptions
+	Pyramid        png.PyramidOptions
+	MaxMem         int
+	TilesDir       string
+	TileWidth      int
+	TileHeight     int
+	Fit            string
+	FitFill        color.RGBA64
+	SmartMeasure   string
+	TileFilter     string
+	UpscaleFilter  string
+	RunMode        string
+	Threads        int
+	Upscale        int
+	OutWidth       int
+	OutHeight      int
+	Megapixels     float64
+	KeepAspect     bool
+	Edge           string
+	GridCols       int
+	GridRows       int
+	Gap            int
+	GroutColor     color.RGBA64
+	GroutTexture   string
+	Corner         float64
+	Intensity      float64
+	Blendin        float64
+	Match          string
+	Feature        string
+	Metric         string
+	GridSize       int
+	ColorDist      string
+	TopK           int
+	Temperature    float64
+	Seed           int64
+	Layout         string
+	HexOrient      string
+	RowOffset      float64
+	VoronoiSeeds   string
+	MinTile        int
+	SplitThreshold float64
+	SplitMeasure   string
+	MaxUses        int
+	MinDist        float64
+	DistNorm       string
+	Refine         int
+	RefineTime     time.Duration
+	RefinePenalty  float64
 }
 
 // ErrorCheck checks for error, then if one exists, prints it then exit the application
@@ -25,8 +69,18 @@ func ErrorCheck(err error) {
 	}
 }
 
-// Schedule runs the correct version based on the Mode field of the configuration value
+// saveOutput saves the output image, as a tile pyramid encoded by the given number of goroutines if the output
+// path is a Deep Zoom descriptor
+func saveOutput(config *Config, outImg *png.Image, threads int) error {
+	if png.IsPyramidPath(config.OutImg) {
+		return outImg.SavePyramid(config.OutImg, &config.Pyramid, &config.Encode, threads)
+	}
+	return outImg.SaveWith(config.OutImg, &config.Encode)
+}
+
+// Schedule runs the correct version based on the Mode field of the configuration value, after fitting the grid
 func Schedule(config *Config) {
+	ErrorCheck(FitGrid(config))
 	if config.RunMode == "s" {
 		RunSequential(config)
 	} else if config.RunMode == "p" {
@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"proj3/png"
+	"testing"
+)
+
+// writeFixture writes a small input image and a directory of tile images into dir
+func writeFixture(t *testing.T, dir string) {
+	t.Helper()
+	inImg := png.NewImage(48, 36)
+	for y := 0; y < 36; y++ {
+		for x := 0; x < 48; x++ {
+			inImg.Set(x, y, color.RGBA64{uint16(x * 1365), uint16(y * 1820), uint16((x * y * 97) % 65536), 0xffff})
+		}
+	}
+	if err := inImg.Save(filepath.Join(dir, "in.png")); err != nil {
+		t.Fatal(err)
+	}
+	tilesDir := filepath.Join(dir, "tiles")
+	if err := os.Mkdir(tilesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		tileImg := png.NewImage(16, 16)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				tileImg.Set(x, y, color.RGBA64{uint16(i * 5461), uint16(x * 4096), uint16((11 - i) * 5461 / (1 + y%3)), 0xffff})
+			}
+		}
+		if err := tileImg.Save(filepath.Join(tilesDir, fmt.Sprintf("t%02d.png", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testLayout loads the tiles of the fixture of the config and lays out its cells, as the schedulers do
+func testLayout(t *testing.T, config *Config) (*Matcher, []*Cell, reference) {
+	t.Helper()
+	inImg, err := png.Load(config.InImg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(config.TilesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiles := []*Tile{}
+	for _, file := range files {
+		tileImg, meta, err := png.LoadWithMetadata(filepath.Join(config.TilesDir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tiles = append(tiles, newTile(config, file.Name(), fitTile(config, tileImg), meta))
+	}
+	ref := newReference(config, inImg)
+	return newMatcher(config, tiles), layoutCells(config, ref), ref
+}
+
+// testConfig returns the configuration of the command line defaults, rendering the fixture in dir
+func testConfig(dir string) *Config {
+	return &Config{
+		InImg:          filepath.Join(dir, "in.png"),
+		Encode:         png.DefaultEncodeOptions,
+		Pyramid:        png.DefaultPyramidOptions,
+		TilesDir:       filepath.Join(dir, "tiles"),
+		TileWidth:      8,
+		TileHeight:     8,
+		Fit:            "stretch",
+		SmartMeasure:   "entropy",
+		TileFilter:     "nearest",
+		UpscaleFilter:  "nearest",
+		Upscale:        2,
+		KeepAspect:     true,
+		Edge:           "crop",
+		GroutColor:     color.RGBA64{0x8080, 0x8080, 0x8080, 0xffff},
+		Intensity:      0.8,
+		Blendin:        0.8,
+		Match:          "random",
+		Feature:        "color",
+		Metric:         "chi",
+		GridSize:       3,
+		ColorDist:      "rgb",
+		TopK:           16,
+		Temperature:    1,
+		Seed:           7,
+		Layout:         "grid",
+		HexOrient:      "pointy",
+		RowOffset:      0.5,
+		VoronoiSeeds:   "poisson",
+		MinTile:        2,
+		SplitThreshold: 0.1,
+		SplitMeasure:   "variance",
+		DistNorm:       "manhattan",
+		RefinePenalty:  1,
+	}
+}
+
+// TestReproducible renders the fixture in every running mode with several thread counts and checks that
+// all outputs are byte-identical
+func TestReproducible(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir)
+	tests := []struct {
+		name  string
+		apply func(config *Config)
+	}{
+		{"random", func(config *Config) {}},
+		{"best", func(config *Config) { config.Match = "best" }},
+		{"softmax", func(config *Config) { config.Match = "softmax" }},
+		{"reuse limits", func(config *Config) { config.Match = "best"; config.MaxUses = 4; config.MinDist = 2 }},
+		{"assign", func(config *Config) { config.Match = "assign" }},
+		{"refine", func(config *Config) { config.Match = "best"; config.MinDist = 2; config.Refine = 2000 }},
+		{"quadtree", func(config *Config) { config.Layout = "quadtree"; config.Match = "best" }},
+		{"hex", func(config *Config) { config.Layout = "hex" }},
+		{"voronoi", func(config *Config) { config.Layout = "voronoi"; config.VoronoiSeeds = "detail" }},
+		{"herringbone", func(config *Config) { config.Layout = "herringbone"; config.TileWidth = 16; config.Gap = 1 }},
+		{"strips", func(config *Config) { config.Match = "best"; config.MaxMem = 1 }},
+	}
+	runs := []struct {
+		mode    string
+		threads int
+	}{{"s", 1}, {"p", 1}, {"p", 3}, {"p", 8}, {"w", 1}, {"w", 3}, {"w", 8}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var want []byte
+			for _, run := range runs {
+				config := testConfig(dir)
+				test.apply(config)
+				config.RunMode, config.Threads = run.mode, run.threads
+				config.OutImg = filepath.Join(dir, fmt.Sprintf("out-%s-%s%d.png", test.name, run.mode, run.threads))
+				Schedule(config)
+				got, err := os.ReadFile(config.OutImg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want == nil {
+					want = got
+				} else if !bytes.Equal(got, want) {
+					t.Errorf("-M %s -T %d output differs from -M s", run.mode, run.threads)
+				}
+			}
+		})
+	}
+}
+
+// TestScheduleFitsGrid checks that Schedule distributes an explicit output size over the grid when the
+// caller did not fit it, and that fitting again keeps the grid
+func TestScheduleFitsGrid(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir)
+	config := testConfig(dir)
+	config.RunMode, config.Threads = "s", 1
+	config.OutImg = filepath.Join(dir, "out-fit.png")
+	// the 48x36 input becomes 50x38, which is closest to 6x5 cells of 8x8
+	config.OutWidth = 50
+	Schedule(config)
+	if config.GridCols != 6 || config.GridRows != 5 || config.TileWidth != 9 || config.TileHeight != 8 {
+		t.Fatalf("grid %dx%d of %dx%d tiles, want 6x5 of 9x8", config.GridCols, config.GridRows, config.TileWidth, config.TileHeight)
+	}
+	if err := FitGrid(config); err != nil || config.GridCols != 6 || config.TileWidth != 9 {
+		t.Fatalf("fitting again gave %d columns of %d pixels, %v", config.GridCols, config.TileWidth, err)
+	}
+	outImg, err := png.Load(config.OutImg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := outImg.Bounds().Size(); size.X != 50 || size.Y != 38 {
+		t.Fatalf("output size %v, want 50x38", size)
+	}
+}
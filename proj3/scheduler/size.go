@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"proj3/png"
+)
+
+// explicitSize checks if the output size is given instead of the upscaling
+func explicitSize(config *Config) bool {
+	return config.OutWidth > 0 || config.OutHeight > 0 || config.Megapixels > 0
+}
+
+// outputSize computes the size of the output image from the size of the input image. A missing width or
+// height follows the aspect ratio of the input, and with the aspect ratio locked the output fits inside
+// the given width and height
+func outputSize(config *Config, inWidth int, inHeight int) (int, int) {
+	scaled := func(scale float64) (int, int) {
+		return max(1, int(math.Round(float64(inWidth)*scale))), max(1, int(math.Round(float64(inHeight)*scale)))
+	}
+	width, height := config.OutWidth, config.OutHeight
+	switch {
+	case config.Megapixels > 0:
+		return scaled(math.Sqrt(config.Megapixels * 1e6 / float64(inWidth*inHeight)))
+	case width == 0 && height == 0:
+		return inWidth * config.Upscale, inHeight * config.Upscale
+	case height == 0:
+		return width, max(1, int(math.Round(float64(width*inHeight)/float64(inWidth))))
+	case width == 0:
+		return max(1, int(math.Round(float64(height*inWidth)/float64(inHeight)))), height
+	case config.KeepAspect:
+		return scaled(math.Min(float64(width)/float64(inWidth), float64(height)/float64(inHeight)))
+	}
+	return width, height
+}
+
+// upscaleInput resizes the input image to the output size, then applies the edge policy to the part of the
+// output which is not a multiple of the tile size: crop clips the last cells, extend grows the output to the
+// next multiple by repeating the edge pixels, shrink cuts the output to the previous multiple, and distribute
+// spreads the output evenly over the cells. An explicit output size is always distributed
+func upscaleInput(config *Config, inImg *png.Image) *png.Image {
+	width, height := outputSize(config, inImg.Bounds().Dx(), inImg.Bounds().Dy())
+	outImg := inImg.ResizeFilter(width, height, config.UpscaleFilter)
+	if window := outputWindow(config, width, height); window != outImg.Bounds() {
+		outImg = outImg.SubsizeExtend(window)
+	}
+	return outImg
+}
+
+// outputWindow applies the edge policy to the resized input image of the size, returning the window of it
+// which is the output. Windows outside the resized input repeat its edge pixels. Distributed grids are
+// fitted by FitGrid, and keep the whole resized input
+func outputWindow(config *Config, width int, height int) image.Rectangle {
+	switch {
+	case explicitSize(config) || config.Edge == "distribute":
+	case config.Edge == "extend":
+		return edgeWindow(config, width, height, true)
+	case config.Edge == "shrink":
+		return edgeWindow(config, width, height, false)
+	}
+	return image.Rect(0, 0, width, height)
+}
+
+// edgeWindow centers a window on the output whose size is a multiple of the tile size, grown to the
+// next multiple or cut to the previous one but at least one tile
+func edgeWindow(config *Config, width int, height int, grow bool) image.Rectangle {
+	cols, rows := width/config.TileWidth, height/config.TileHeight
+	if grow {
+		cols = (width + config.TileWidth - 1) / config.TileWidth
+		rows = (height + config.TileHeight - 1) / config.TileHeight
+	}
+	newWidth := max(cols, 1) * config.TileWidth
+	newHeight := max(rows, 1) * config.TileHeight
+	x0 := (width - newWidth) / 2
+	y0 := (height - newHeight) / 2
+	return image.Rect(x0, y0, x0+newWidth, y0+newHeight)
+}
+
+// FitGrid fits the grid to the output if the output size is explicit or the edge policy is distribute, reading
+// only the size of the input image. It changes the tile size, so Schedule calls it before the tiles are loaded.
+// A grid which is already fitted is kept
+func FitGrid(config *Config) error {
+	if config.GridCols > 0 || !(explicitSize(config) || config.Edge == "distribute") {
+		return nil
+	}
+	inWidth, inHeight, err := png.LoadSize(config.InImg)
+	if err != nil {
+		return err
+	}
+	width, height := outputSize(config, inWidth, inHeight)
+	distributeGrid(config, width, height)
+	return nil
+}
+
+// distributeGrid gives the grid the number of columns and rows which is closest to the tile size, and
+// enlarges the tiles to the largest cell, so that the cells cover the output exactly
+func distributeGrid(config *Config, width int, height int) {
+	config.GridCols = max(1, int(math.Round(float64(width)/float64(config.TileWidth))))
+	config.GridRows = max(1, int(math.Round(float64(height)/float64(config.TileHeight))))
+	config.TileWidth = (width + config.GridCols - 1) / config.GridCols
+	config.TileHeight = (height + config.GridRows - 1) / config.GridRows
+}
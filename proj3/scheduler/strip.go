@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"proj3/png"
+	"sort"
+)
+
+// reference provides the upscaled input image, which the cells are laid out on and matched to
+type reference interface {
+	Bounds() image.Rectangle
+	// visit calls fn for groups of the rectangles, given by their indices, with an image holding the rows of the group
+	visit(rects []image.Rectangle, fn func(img *png.Image, indices []int))
+}
+
+// wholeReference is an upscaled input image held in memory as a whole
+type wholeReference struct {
+	*png.Image
+}
+
+func (ref wholeReference) visit(rects []image.Rectangle, fn func(img *png.Image, indices []int)) {
+	indices := make([]int, len(rects))
+	for i := range indices {
+		indices[i] = i
+	}
+	fn(ref.Image, indices)
+}
+
+// newReference upscales the input image, or prepares upscaling it band by band if the memory is limited
+func newReference(config *Config, inImg *png.Image) reference {
+	if config.MaxMem > 0 {
+		return newStripReference(config, inImg)
+	}
+	return wholeReference{upscaleInput(config, inImg)}
+}
+
+// renderOutput draws the cells onto the upscaled input image with render, then saves it. The output is rendered
+// and saved band by band if the input image is upscaled band by band. The tiles of a tile pyramid are encoded
+// by the given number of goroutines
+func renderOutput(config *Config, ref reference, cells []*Cell, threads int, render func(cells []*Cell, outImg *png.Image)) error {
+	if strips, ok := ref.(*stripReference); ok {
+		return renderStrips(config, strips, cells, render)
+	}
+	outImg := ref.(wholeReference).Image
+	render(cells, outImg)
+	return saveOutput(config, outImg, threads)
+}
+
+// stripReference upscales the rows of the input image on demand, in bands of at most maxRows rows, so that
+// the upscaled input image is never held in memory as a whole. A row of a band takes rowBytes bytes
+type stripReference struct {
+	config   *Config
+	inImg    *png.Image
+	width    int
+	height   int
+	window   image.Rectangle
+	rowBytes int
+	maxRows  int
+}
+
+// newStripReference prepares upscaling the input image band by band, with bands fitting the memory limit.
+// The edge policy is applied as by upscaleInput
+func newStripReference(config *Config, inImg *png.Image) *stripReference {
+	width, height := outputSize(config, inImg.Bounds().Dx(), inImg.Bounds().Dy())
+	window := outputWindow(config, width, height)
+	ref := &stripReference{config: config, inImg: inImg, width: width, height: height, window: window}
+
+	// a band takes 8 bytes per pixel, twice if it is cut from the upscaled rows by the edge policy
+	ref.rowBytes = 8 * window.Dx()
+	if window != image.Rect(0, 0, width, height) {
+		ref.rowBytes *= 2
+	}
+	ref.maxRows = int(int64(config.MaxMem) << 20 / int64(ref.rowBytes))
+	return ref
+}
+
+func (ref *stripReference) Bounds() image.Rectangle {
+	return image.Rect(0, 0, ref.window.Dx(), ref.window.Dy())
+}
+
+// rows upscales the rows from top to bottom of the output, keeping their position in the bounds
+func (ref *stripReference) rows(top int, bottom int) *png.Image {
+	// the upscaled rows under the window, clamped to the upscaled input as the window may extend it
+	clamp := func(y int) int {
+		return min(max(y, 0), ref.height-1)
+	}
+	rowTop, rowBottom := clamp(top+ref.window.Min.Y), clamp(bottom-1+ref.window.Min.Y)+1
+	band := ref.inImg.ResizeFilterRows(ref.width, ref.height, ref.config.UpscaleFilter, rowTop, rowBottom)
+	if ref.window == image.Rect(0, 0, ref.width, ref.height) {
+		return band
+	}
+	band = band.SubsizeExtend(image.Rect(ref.window.Min.X, top+ref.window.Min.Y, ref.window.Max.X, bottom+ref.window.Min.Y))
+	band.Rect = band.Rect.Add(image.Pt(0, top))
+	return band
+}
+
+// visit groups the rectangles from top to bottom into bands which fit the memory limit. A rectangle taller
+// than the limit gets a band of its own
+func (ref *stripReference) visit(rects []image.Rectangle, fn func(img *png.Image, indices []int)) {
+	order := make([]int, len(rects))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rects[order[i]].Min.Y < rects[order[j]].Min.Y
+	})
+	for start := 0; start < len(order); {
+		top, bottom := rects[order[start]].Min.Y, rects[order[start]].Max.Y
+		end := start + 1
+		for end < len(order) && max(bottom, rects[order[end]].Max.Y)-top <= ref.maxRows {
+			bottom = max(bottom, rects[order[end]].Max.Y)
+			end++
+		}
+		bounds := ref.Bounds()
+		fn(ref.rows(max(top, bounds.Min.Y), min(max(bottom, top+1), bounds.Max.Y)), order[start:end])
+		start = end
+	}
+}
+
+// cellRects returns the rectangles of the cells
+func cellRects(cells []*Cell) []image.Rectangle {
+	rects := make([]image.Rectangle, len(cells))
+	for i, cell := range cells {
+		rects[i] = cell.Rect
+	}
+	return rects
+}
+
+// renderStrips renders the output band by band and streams the bands to the PNG output. Each band gets the upscaled
+// rows of every cell crossing it, and render draws those cells onto it. A cell crossing two bands is drawn for
+// both, which gives the same pixels as the cells only read their own pixels
+func renderStrips(config *Config, ref *stripReference, cells []*Cell, render func(cells []*Cell, band *png.Image)) error {
+	tallest := 1
+	for _, cell := range cells {
+		tallest = max(tallest, cell.Rect.Dy())
+	}
+	step := ref.maxRows - 2*(tallest-1)
+	if step < 1 {
+		needed := (int64(2*tallest-1)*int64(ref.rowBytes) + 1<<20 - 1) >> 20
+		return fmt.Errorf("'max-mem' is too small for cells of %d pixels high, which need at least %d MB", tallest, needed)
+	}
+
+	sorted := append([]*Cell{}, cells...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rect.Min.Y < sorted[j].Rect.Min.Y
+	})
+
+	outWriter, err := os.Create(config.OutImg)
+	if err != nil {
+		return err
+	}
+	defer outWriter.Close()
+	bounds := ref.Bounds()
+	encoder, err := png.NewStreamEncoder(outWriter, bounds.Dx(), bounds.Dy(), &config.Encode)
+	if err != nil {
+		return err
+	}
+
+	for y0 := 0; y0 < bounds.Dy(); y0 += step {
+		y1 := min(y0+step, bounds.Dy())
+		// the cells crossing the band start less than the tallest cell above it
+		first := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].Rect.Min.Y > y0-tallest
+		})
+		top, bottom := y0, y1
+		bandCells := []*Cell{}
+		for _, cell := range sorted[first:] {
+			if cell.Rect.Min.Y >= y1 {
+				break
+			}
+			if cell.Rect.Max.Y <= y0 || cell.Rect.Empty() {
+				continue
+			}
+			bandCells = append(bandCells, cell)
+			top, bottom = min(top, cell.Rect.Min.Y), max(bottom, cell.Rect.Max.Y)
+		}
+		band := ref.rows(top, bottom)
+		render(bandCells, band)
+		if err := encoder.WriteRows(band, y0, y1); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return outWriter.Close()
+}
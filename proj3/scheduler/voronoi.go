@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// poissonAttempts is the number of candidates tried around an active seed of the Poisson-disk sampling
+const poissonAttempts = 30
+
+// seedCount computes the number of Voronoi seeds, which gives the cells the mean area of a tile
+func seedCount(config *Config, bounds image.Rectangle) int {
+	return max(1, int(math.Round(float64(bounds.Dx()*bounds.Dy())/float64(config.TileWidth*config.TileHeight))))
+}
+
+// uniformSeeds scatters the seeds uniformly over the bounds. Seed positions are in pixels
+func uniformSeeds(config *Config, bounds image.Rectangle, rng *rand.Rand) []point {
+	seeds := make([]point, seedCount(config, bounds))
+	for i := range seeds {
+		seeds[i] = point{
+			float64(bounds.Min.X) + rng.Float64()*float64(bounds.Dx()),
+			float64(bounds.Min.Y) + rng.Float64()*float64(bounds.Dy()),
+		}
+	}
+	return seeds
+}
+
+// detailSeeds scatters the seeds with a density following the detail of the input image, measured in blocks
+// of half a tile, so that the cells are smaller where the input has detail
+func detailSeeds(config *Config, ref reference, bounds image.Rectangle, rng *rand.Rand) []point {
+	blockWidth, blockHeight := max(config.TileWidth/2, 1), max(config.TileHeight/2, 1)
+	blocks := []image.Rectangle{}
+	for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += blockHeight {
+		for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += blockWidth {
+			blocks = append(blocks, image.Rect(x0, y0, x0+blockWidth, y0+blockHeight).Intersect(bounds))
+		}
+	}
+	details := measureDetail(config, ref, blocks)
+	weights := make([]float64, len(blocks))
+	var total float64
+	for i, block := range blocks {
+		// a small base weight keeps flat areas from being left without seeds
+		total += (details[i] + 0.01) * float64(block.Dx()*block.Dy())
+		weights[i] = total
+	}
+	seeds := make([]point, seedCount(config, bounds))
+	for i := range seeds {
+		block := blocks[min(sort.SearchFloat64s(weights, rng.Float64()*total), len(blocks)-1)]
+		seeds[i] = point{
+			float64(block.Min.X) + rng.Float64()*float64(block.Dx()),
+			float64(block.Min.Y) + rng.Float64()*float64(block.Dy()),
+		}
+	}
+	return seeds
+}
+
+// poissonSeeds scatters the seeds by Poisson-disk sampling, which keeps them at least a minimum distance apart.
+// The distance is chosen so that the seeds are about as dense as the tiles
+func poissonSeeds(config *Config, bounds image.Rectangle, rng *rand.Rand) []point {
+	radius := 0.8 * math.Sqrt(float64(config.TileWidth*config.TileHeight))
+	side := radius / math.Sqrt2
+	cols := int(math.Ceil(float64(bounds.Dx())/side)) + 1
+	rows := int(math.Ceil(float64(bounds.Dy())/side)) + 1
+
+	// each grid square holds at most one seed
+	grid := make([]int, cols*rows)
+	for i := range grid {
+		grid[i] = -1
+	}
+	gridAt := func(p point) (int, int) {
+		return int((p.X - float64(bounds.Min.X)) / side), int((p.Y - float64(bounds.Min.Y)) / side)
+	}
+	seeds := []point{}
+	active := []int{}
+	add := func(p point) {
+		col, row := gridAt(p)
+		grid[row*cols+col] = len(seeds)
+		active = append(active, len(seeds))
+		seeds = append(seeds, p)
+	}
+	fits := func(p point) bool {
+		if p.X < float64(bounds.Min.X) || p.X >= float64(bounds.Max.X) || p.Y < float64(bounds.Min.Y) || p.Y >= float64(bounds.Max.Y) {
+			return false
+		}
+		col, row := gridAt(p)
+		for r := max(row-2, 0); r <= min(row+2, rows-1); r++ {
+			for c := max(col-2, 0); c <= min(col+2, cols-1); c++ {
+				if i := grid[r*cols+c]; i >= 0 && math.Hypot(seeds[i].X-p.X, seeds[i].Y-p.Y) < radius {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	add(point{
+		float64(bounds.Min.X) + rng.Float64()*float64(bounds.Dx()),
+		float64(bounds.Min.Y) + rng.Float64()*float64(bounds.Dy()),
+	})
+	for len(active) > 0 {
+		i := rng.Intn(len(active))
+		seed := seeds[active[i]]
+		found := false
+		for attempt := 0; attempt < poissonAttempts && !found; attempt++ {
+			angle := rng.Float64() * 2 * math.Pi
+			dist := radius * (1 + rng.Float64())
+			candidate := point{seed.X + dist*math.Cos(angle), seed.Y + dist*math.Sin(angle)}
+			if fits(candidate) {
+				add(candidate)
+				found = true
+			}
+		}
+		if !found {
+			active[i] = active[len(active)-1]
+			active = active[:len(active)-1]
+		}
+	}
+	return seeds
+}
+
+// voronoiCells scatters seeds over the bounds based on the seed mode and assigns every pixel to the nearest
+// seed, preferring the first seed on ties. Each cell covers the pixels of its seed. Its bounding box is its
+// tile window, which the tile is resized to, as the cells are often larger than a tile. Voronoi cells have no
+// rows, so Col is the position of the cell among the cells ordered by their seeds column by column
+func voronoiCells(config *Config, ref reference, bounds image.Rectangle) []*Cell {
+	rng := rand.New(rand.NewSource(mix(config.Seed, -2)))
+	var seeds []point
+	switch config.VoronoiSeeds {
+	case "uniform":
+		seeds = uniformSeeds(config, bounds, rng)
+	case "detail":
+		seeds = detailSeeds(config, ref, bounds, rng)
+	default:
+		seeds = poissonSeeds(config, bounds, rng)
+	}
+	sort.SliceStable(seeds, func(i, j int) bool {
+		if seeds[i].X != seeds[j].X {
+			return seeds[i].X < seeds[j].X
+		}
+		return seeds[i].Y < seeds[j].Y
+	})
+
+	// buckets the seeds, then searches rings of buckets around each pixel until no closer seed can exist
+	side := float64(max(config.TileWidth, config.TileHeight))
+	cols := int(math.Ceil(float64(bounds.Dx())/side)) + 1
+	rows := int(math.Ceil(float64(bounds.Dy())/side)) + 1
+	buckets := make([][]int, cols*rows)
+	for i, seed := range seeds {
+		col := int((seed.X - float64(bounds.Min.X)) / side)
+		row := int((seed.Y - float64(bounds.Min.Y)) / side)
+		buckets[row*cols+col] = append(buckets[row*cols+col], i)
+	}
+	nearestSeed := func(x int, y int) int {
+		px, py := float64(x)+0.5, float64(y)+0.5
+		col := int((px - float64(bounds.Min.X)) / side)
+		row := int((py - float64(bounds.Min.Y)) / side)
+		best, bestDist := -1, math.Inf(1)
+		for ring := 0; ring <= max(cols, rows); ring++ {
+			for r := row - ring; r <= row+ring; r++ {
+				for c := col - ring; c <= col+ring; c++ {
+					if r < 0 || r >= rows || c < 0 || c >= cols || max(abs(r-row), abs(c-col)) != ring {
+						continue
+					}
+					for _, i := range buckets[r*cols+c] {
+						dist := (seeds[i].X-px)*(seeds[i].X-px) + (seeds[i].Y-py)*(seeds[i].Y-py)
+						if dist < bestDist || (dist == bestDist && i < best) {
+							best, bestDist = i, dist
+						}
+					}
+				}
+			}
+			if best >= 0 && math.Sqrt(bestDist) <= float64(ring)*side {
+				break
+			}
+		}
+		return best
+	}
+
+	cells := regionCells(bounds, nearestSeed, func(seed int) *Cell {
+		return &Cell{Col: seed}
+	})
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].Col < cells[j].Col
+	})
+	for i, cell := range cells {
+		cell.Col = i
+		cell.Origin = cell.Rect.Min
+		cell.Size = cell.Rect.Size()
+	}
+	return cells
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
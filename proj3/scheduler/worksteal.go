@@ -2,7 +2,6 @@ package scheduler
 
 import (
 	"fmt"
-	"image"
 	"io/fs"
 	"math/rand"
 	"os"
@@ -10,60 +9,58 @@ import (
 	"proj3/deque"
 	"proj3/png"
 	"runtime"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // generateTile generates tile image from directory entry
-func generateTile(config *Config, file fs.DirEntry) *png.Image {
+func generateTile(config *Config, file fs.DirEntry) *Tile {
 	filename := file.Name()
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext != ".png" {
+	if file.IsDir() {
 		return nil
 	}
 	tilePath := filepath.Join(config.TilesDir, filename)
-	tileImg, err := png.Load(tilePath)
+	tileImg, meta, err := png.LoadWithMetadata(tilePath)
 	if err != nil {
 		return nil
 	}
-	tileImg = tileImg.Resize(config.TileSize, config.TileSize)
-	return tileImg
+	tileImg = fitTile(config, tileImg)
+	return newTile(config, filename, tileImg, meta)
 }
 
-// createMosaic applies color effects to input image in a specific tile position
-func createMosaic(config *Config, bounds *image.Rectangle, outImg *png.Image, tileImgs []*png.Image) bool {
-	refImg := outImg.Subsize(*bounds)
-	tileImg := tileImgs[rand.Intn(len(tileImgs))]
-	colorTileImg := tileImg.ColorTransfer(refImg)
-	for x := 0; x < bounds.Dx(); x++ {
-		for y := 0; y < bounds.Dy(); y++ {
-			blendTileColor := png.ColorBlend(
-				png.ColortoRGBA64(tileImg.At(x, y)),
-				png.ColortoRGBA64(colorTileImg.At(x, y)),
-				config.Blendin,
-			)
-			outColor := png.ColorBlend(
-				png.ColortoRGBA64(outImg.At(x+bounds.Min.X, y+bounds.Min.Y)),
-				blendTileColor,
-				config.Intensity,
-			)
-			outImg.Set(x+bounds.Min.X, y+bounds.Min.Y, outColor)
+// workStealTileGenerator pops tasks from its deque, then tries to steals tasks from other deques if empty
+func workStealTileGenerator(config *Config, id int, deques []*deque.BoundDeque, tileChannel chan<- *Tile, done *atomic.Bool) {
+	task := deques[id].PopBottom()
+	for {
+		for task != nil {
+			tile := generateTile(config, task.(fs.DirEntry))
+			tileChannel <- tile
+			task = deques[id].PopBottom()
+		}
+		for task == nil {
+			if done.Load() {
+				return
+			}
+			runtime.Gosched()
+			victim := rand.Intn(len(deques))
+			if !deques[victim].IsEmpty() {
+				task = deques[victim].PopTop()
+			}
 		}
 	}
-	return true
 }
 
-// workStealTileGenerator pops tasks from its deque, then tries to steals tasks from other deques if empty
-func workStealTileGenerator(config *Config, id int, deques []*deque.BoundDeque, tileChannel chan<- *png.Image, done *bool) {
+// workStealMosaicWorker pops tasks from its deque, then tries to steals tasks from other deques if empty
+func workStealMosaicWorker(config *Config, id int, deques []*deque.BoundDeque, outImg *png.Image, matcher *Matcher, grout *grout, boolChannel chan<- bool, done *atomic.Bool) {
 	task := deques[id].PopBottom()
 	for {
 		for task != nil {
-			tileImg := generateTile(config, task.(fs.DirEntry))
-			tileChannel <- tileImg
+			resp := createMosaic(config, task.(*Cell), outImg, matcher, grout)
+			boolChannel <- resp
 			task = deques[id].PopBottom()
 		}
 		for task == nil {
-			if *done {
+			if done.Load() {
 				return
 			}
 			runtime.Gosched()
@@ -75,17 +72,17 @@ func workStealTileGenerator(config *Config, id int, deques []*deque.BoundDeque,
 	}
 }
 
-// workStealMosaicWorker pops tasks from its deque, then tries to steals tasks from other deques if empty
-func workStealMosaicWorker(config *Config, id int, deques []*deque.BoundDeque, outImg *png.Image, tileImgs []*png.Image, boolChannel chan<- bool, done *bool) {
+// workStealRefineWorker pops regions from its deque, then tries to steals regions from other deques if empty
+func workStealRefineWorker(refiner *refiner, id int, deques []*deque.BoundDeque, boolChannel chan<- bool, done *atomic.Bool) {
 	task := deques[id].PopBottom()
 	for {
 		for task != nil {
-			resp := createMosaic(config, task.(*image.Rectangle), outImg, tileImgs)
+			resp := refiner.refineRegion(task.(*region))
 			boolChannel <- resp
 			task = deques[id].PopBottom()
 		}
 		for task == nil {
-			if *done {
+			if done.Load() {
 				return
 			}
 			runtime.Gosched()
@@ -97,6 +94,35 @@ func workStealMosaicWorker(config *Config, id int, deques []*deque.BoundDeque, o
 	}
 }
 
+// refineWorkSteal runs the refinement, refining the regions of the same color with work stealing
+func refineWorkSteal(config *Config, refiner *refiner) {
+	refiner.run(func(regions []*region) {
+		var regionDone atomic.Bool
+		boolChannel := make(chan bool, config.Threads)
+
+		// pushes regions to deque in each thread
+		deques := make([]*deque.BoundDeque, config.Threads)
+		for i := 0; i < config.Threads; i++ {
+			deques[i] = deque.NewBoundDeque((len(regions) / config.Threads) + 1)
+		}
+		for i, reg := range regions {
+			deques[i%config.Threads].PushBottom(reg)
+		}
+
+		// runs the refine worker
+		for i := 0; i < config.Threads; i++ {
+			go workStealRefineWorker(refiner, i, deques, boolChannel, &regionDone)
+		}
+
+		for i := 0; i < len(regions); i++ {
+			<-boolChannel
+		}
+
+		regionDone.Store(true)
+		close(boolChannel)
+	})
+}
+
 // RunWorkSteal runs the fork join with work stealing version of the mosaic collage generator
 func RunWorkSteal(config *Config) {
 	// First part: creating upscaled input image and resized tile images
@@ -108,13 +134,14 @@ func RunWorkSteal(config *Config) {
 	ErrorCheck(err)
 	files, err := os.ReadDir(config.TilesDir)
 	ErrorCheck(err)
+	grout, err := newGrout(config)
+	ErrorCheck(err)
 
-	outImg := inImg.Resize(inImg.Bounds().Dx()*config.Upscale, inImg.Bounds().Dy()*config.Upscale)
-	bounds := outImg.Bounds()
+	ref := newReference(config, inImg)
 
-	tileDone := false
-	tileImgs := []*png.Image{}
-	tileChannel := make(chan *png.Image, len(files))
+	var tileDone atomic.Bool
+	tiles := []*Tile{}
+	tileChannel := make(chan *Tile, len(files))
 
 	// pushes tile generating tasks to deque in each thread
 	deques := make([]*deque.BoundDeque, config.Threads)
@@ -131,14 +158,15 @@ func RunWorkSteal(config *Config) {
 	}
 
 	for i := 0; i < len(files); i++ {
-		tileImg := <-tileChannel
-		if tileImg != nil {
-			tileImgs = append(tileImgs, tileImg)
+		tile := <-tileChannel
+		if tile != nil {
+			tiles = append(tiles, tile)
 		}
 	}
 
-	tileDone = true
+	tileDone.Store(true)
 	close(tileChannel)
+	matcher := newMatcher(config, tiles)
 
 	endTime = time.Since(startTime).Seconds()
 	fmt.Printf("%.2f\n", endTime)
@@ -146,41 +174,38 @@ func RunWorkSteal(config *Config) {
 	// Second part: applying color transfer to tile images, then add it input image position
 	startTime = time.Now()
 
-	rectDone := false
-	rects := []*image.Rectangle{}
-	boolChannel := make(chan bool, config.Threads)
-
-	// pushes tile positions to deque in each thread
-	for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += config.TileSize {
-		for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += config.TileSize {
-			x1 := min(x0+config.TileSize, bounds.Max.X)
-			y1 := min(y0+config.TileSize, bounds.Max.Y)
-			rect := image.Rect(x0, y0, x1, y1)
-			rects = append(rects, &rect)
-		}
+	cells := layoutCells(config, ref)
+	matcher.Plan(cells, ref, config.Threads)
+	if config.Refine > 0 || config.RefineTime > 0 {
+		refineWorkSteal(config, newRefiner(matcher, cells, ref, config.Threads))
 	}
 
-	deques = make([]*deque.BoundDeque, config.Threads)
-	for i := 0; i < config.Threads; i++ {
-		deques[i] = deque.NewBoundDeque((len(rects) / config.Threads) + 1)
-	}
-	for i, rect := range rects {
-		deques[i%config.Threads].PushBottom(rect)
-	}
+	ErrorCheck(renderOutput(config, ref, cells, config.Threads, func(cells []*Cell, outImg *png.Image) {
+		var rectDone atomic.Bool
+		boolChannel := make(chan bool, config.Threads)
 
-	// runs the mosaic worker
-	for i := 0; i < config.Threads; i++ {
-		go workStealMosaicWorker(config, i, deques, outImg, tileImgs, boolChannel, &rectDone)
-	}
+		// pushes tile positions to deque in each thread. Cells of an adaptive layout differ in cost,
+		// which is balanced by stealing
+		deques := make([]*deque.BoundDeque, config.Threads)
+		for i := 0; i < config.Threads; i++ {
+			deques[i] = deque.NewBoundDeque((len(cells) / config.Threads) + 1)
+		}
+		for i, cell := range cells {
+			deques[i%config.Threads].PushBottom(cell)
+		}
 
-	for i := 0; i < len(rects); i++ {
-		<-boolChannel
-	}
+		// runs the mosaic worker
+		for i := 0; i < config.Threads; i++ {
+			go workStealMosaicWorker(config, i, deques, outImg, matcher, grout, boolChannel, &rectDone)
+		}
 
-	rectDone = true
-	close(boolChannel)
+		for i := 0; i < len(cells); i++ {
+			<-boolChannel
+		}
 
-	outImg.Save(config.OutImg)
+		rectDone.Store(true)
+		close(boolChannel)
+	}))
 
 	endTime = time.Since(startTime).Seconds()
 	fmt.Printf("%.2f\n", endTime)